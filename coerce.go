@@ -0,0 +1,255 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ToBool converts a decoded value to a bool like JS's Boolean(v): null,
+// undefined, false, 0, -0, NaN, 0n and "" are false, everything else is
+// true, including boxed primitives and empty objects and arrays.
+func ToBool(v any) bool {
+	switch t := v.(type) {
+	case nil, UndefinedValue:
+		return false
+	case bool:
+		return t
+	case int32:
+		return t != 0
+	case int64:
+		return t != 0
+	case float64:
+		return t != 0 && !math.IsNaN(t)
+	case Number:
+		f, _ := t.Float64()
+		return f != 0 && !math.IsNaN(f)
+	case string:
+		return t != ""
+	case StringBytes:
+		return len(t) > 0
+	case *big.Int:
+		return t.Sign() != 0
+	}
+	return true
+}
+
+// ToInt converts a decoded value to an integer like JS's
+// Math.trunc(Number(v)), with these rules for Number(v): null, false
+// and "" are 0, true is 1, strings are parsed as decimal, hexadecimal,
+// octal or binary literals after trimming whitespace, boxed primitives
+// are unwrapped, arrays go through ToString, and everything else is NaN.
+// BigInts are converted directly. The bool is false when the result is
+// NaN, infinite or doesn't fit in an int64.
+func ToInt(v any) (int64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return t, true
+	case *big.Int:
+		return t.Int64(), t.IsInt64()
+	}
+	f := math.Trunc(toNumber(v))
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// ToString converts a decoded value to a string like JS's String(v).
+// Numbers are formatted like Number.prototype.toString(), arrays and
+// typed arrays join their elements with commas, where null and undefined
+// elements are empty, and other objects are "[object Object]" or similar.
+func ToString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case UndefinedValue:
+		return "undefined"
+	case bool:
+		return strconv.FormatBool(t)
+	case int32:
+		return strconv.Itoa(int(t))
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case float64:
+		return formatNumber(t)
+	case Number:
+		return string(t)
+	case string:
+		return t
+	case StringBytes:
+		return string(t)
+	case *big.Int:
+		return t.String()
+	case BoxedBool:
+		return ToString(t.Value)
+	case BoxedNumber:
+		return ToString(t.Value)
+	case BoxedString:
+		return t.Value
+	case RegExp:
+		return t.String()
+	case []any:
+		s := make([]string, len(t))
+		for i, e := range t {
+			if e != nil && e != Undefined {
+				s[i] = ToString(e)
+			}
+		}
+		return strings.Join(s, ",")
+	case map[string]any, *Object:
+		return "[object Object]"
+	case ArrayBuffer, []byte:
+		return "[object ArrayBuffer]"
+	case Uint8ClampedArray:
+		s := make([]string, len(t.Bytes))
+		for i, b := range t.Bytes {
+			s[i] = strconv.Itoa(int(b))
+		}
+		return strings.Join(s, ",")
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		s := make([]string, rv.Len())
+		for i := range s {
+			e := rv.Index(i)
+			switch {
+			case e.CanFloat():
+				s[i] = formatNumber(e.Float())
+			case e.CanInt():
+				s[i] = strconv.FormatInt(e.Int(), 10)
+			case e.CanUint():
+				s[i] = strconv.FormatUint(e.Uint(), 10)
+			}
+		}
+		return strings.Join(s, ",")
+	}
+	return "[object Object]"
+}
+
+func toNumber(v any) float64 {
+	switch t := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	case int32:
+		return float64(t)
+	case float64:
+		return t
+	case Number:
+		f, _ := t.Float64()
+		return f
+	case string:
+		return stringToNumber(t)
+	case StringBytes:
+		return stringToNumber(string(t))
+	case BoxedBool:
+		return toNumber(t.Value)
+	case BoxedNumber:
+		return t.Value
+	case BoxedString:
+		return stringToNumber(t.Value)
+	case []any:
+		return stringToNumber(ToString(t))
+	}
+	return math.NaN()
+}
+
+// stringToNumber is StringToNumber from the ECMAScript spec, more or less.
+func stringToNumber(s string) float64 {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return 0
+	case "Infinity", "+Infinity":
+		return math.Inf(1)
+	case "-Infinity":
+		return math.Inf(-1)
+	}
+	if len(s) > 2 && s[0] == '0' {
+		base := 0
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 0 {
+			n, ok := new(big.Int).SetString(s[2:], base)
+			if !ok || strings.ContainsAny(s[2:], "+-_") {
+				return math.NaN()
+			}
+			f, _ := new(big.Float).SetInt(n).Float64()
+			return f
+		}
+	}
+	// strconv.ParseFloat also accepts "inf", "nan", hex floats and
+	// underscores, JS doesn't
+	if strings.Trim(s, "0123456789+-.eE") != "" {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && err.(*strconv.NumError).Err != strconv.ErrRange {
+		return math.NaN() // out of range is ±Infinity or 0, like JS
+	}
+	return f
+}
+
+// formatNumber is Number::toString from the ECMAScript spec, for radix 10.
+func formatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0" // also -0
+	case f < 0:
+		return "-" + formatNumber(-f)
+	}
+	// shortest round-trip digits and exponent, as in d.ddde±x
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mant, exp, _ := strings.Cut(s, "e")
+	digits := strings.Replace(mant, ".", "", 1)
+	e, _ := strconv.Atoi(exp)
+	k, n := len(digits), e+1
+	switch {
+	case k <= n && n <= 21:
+		return digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return "0." + strings.Repeat("0", -n) + digits
+	}
+	s = digits[:1]
+	if k > 1 {
+		s += "." + digits[1:]
+	}
+	if n-1 >= 0 {
+		return s + "e+" + strconv.Itoa(n-1)
+	}
+	return s + "e" + strconv.Itoa(n-1)
+}
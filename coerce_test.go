@@ -0,0 +1,109 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestToBool(t *testing.T) {
+	for _, v := range []any{nil, Undefined, false, int32(0), 0.0, math.Copysign(0, -1), math.NaN(), "", Number("0"), big.NewInt(0), StringBytes{}} {
+		expect(false, ToBool(v))
+	}
+	for _, v := range []any{true, int32(5), -1.5, "0", "false", " ", big.NewInt(1), StringBytes("0"),
+		map[string]any{}, []any{}, BoxedBool{false}, BoxedString{""}, []byte{}} {
+		expect(true, ToBool(v))
+	}
+}
+
+func TestToInt(t *testing.T) {
+	for _, c := range []struct {
+		v  any
+		n  int64
+		ok bool
+	}{
+		{"", 0, true},
+		{"5", 5, true},
+		{StringBytes("42"), 42, true},
+		{" -5.9\n", -5, true},
+		{"0x1F", 31, true},
+		{"0b101", 5, true},
+		{"0o17", 15, true},
+		{"1e3", 1000, true},
+		{"1_000", 0, false},
+		{"-0x10", 0, false},
+		{"inf", 0, false},
+		{"Infinity", 0, false},
+		{"5px", 0, false},
+		{int32(0), 0, true},
+		{2.5, 2, true},
+		{math.NaN(), 0, false},
+		{1e300, 0, false},
+		{nil, 0, true},
+		{Undefined, 0, false},
+		{true, 1, true},
+		{Number("42"), 42, true},
+		{BoxedString{"7"}, 7, true},
+		{[]any{}, 0, true},
+		{[]any{"8"}, 8, true},
+		{[]any{int32(1), int32(2)}, 0, false},
+		{map[string]any{}, 0, false},
+		{big.NewInt(-1 << 40), -1 << 40, true},
+		{new(big.Int).Lsh(big.NewInt(1), 64), 0, false},
+	} {
+		n, ok := ToInt(c.v)
+		expect(c.n, n)
+		expect(c.ok, ok)
+	}
+}
+
+func TestToString(t *testing.T) {
+	for _, c := range []struct {
+		v    any
+		want string
+	}{
+		{"", ""},
+		{int32(0), "0"},
+		{"5", "5"},
+		{Undefined, "undefined"},
+		{nil, "null"},
+		{true, "true"},
+		{math.Copysign(0, -1), "0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{1e20, "100000000000000000000"},
+		{123e-20, "1.23e-18"},
+		{0.000001, "0.000001"},
+		{1e-7, "1e-7"},
+		{-2.5e-7, "-2.5e-7"},
+		{math.Inf(-1), "-Infinity"},
+		{math.NaN(), "NaN"},
+		{big.NewInt(-12), "-12"},
+		{[]any{int32(1), nil, "a", Undefined, []any{int32(2), int32(3)}}, "1,,a,,2,3"},
+		{map[string]any{"a": int32(1)}, "[object Object]"},
+		{BoxedNumber{2}, "2"},
+		{[]float64{0.5, 2}, "0.5,2"},
+		{[]uint16{1, 2}, "1,2"},
+		{RegExp{Source: "a+", Global: true}, "/a+/g"},
+		{Clamped([]byte{1, 2}), "1,2"},
+		{StringBytes("ab"), "ab"},
+		{Clamped(nil), ""},
+		{ArrayBuffer{[]byte{1}}, "[object ArrayBuffer]"},
+	} {
+		expect(c.want, ToString(c.v))
+	}
+}
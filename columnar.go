@@ -0,0 +1,72 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DecodeColumnar reads a blob holding an array of objects that all have
+// the same properties and returns it transposed: one column per property,
+// with the values in array order. It decodes one object at a time and
+// doesn't keep the array of objects around.
+func DecodeColumnar(r io.Reader) (cols map[string][]any, err error) {
+	defer recoverError(&err, "serde.DecodeColumnar")
+	d := NewDecoder(&countingReader{r: r})
+	d.readHeader()
+	if tag := readByte(d.r); tag != tagArray {
+		panic(fmt.Sprintf("array expected, have %s", TagName(tag)))
+	}
+	n := readUint32(d.r)
+	d.addRef(incomplete{})
+	cols = map[string][]any{}
+	for i := 0; i < n; i++ {
+		m, ok := d.readValue().(map[string]any)
+		if !ok {
+			panic(fmt.Sprintf("element %d is not an object", i))
+		}
+		if i == 0 {
+			for k := range m {
+				cols[k] = make([]any, 0, preallocLimit(n))
+			}
+		}
+		for k, v := range m {
+			c, ok := cols[k]
+			if !ok {
+				panic(fmt.Sprintf("element %d has property %q, element 0 doesn't", i, k))
+			}
+			cols[k] = append(c, v)
+		}
+		if len(m) < len(cols) {
+			panic(fmt.Sprintf("element %d lacks property %q", i, missingKey(cols, m)))
+		}
+	}
+	return cols, nil
+}
+
+// missingKey returns the first key, in sorted order, of cols that isn't
+// in m.
+func missingKey(cols map[string][]any, m map[string]any) string {
+	keys := []string{}
+	for k := range cols {
+		if _, ok := m[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys[0]
+}
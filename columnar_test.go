@@ -0,0 +1,52 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeColumnar(t *testing.T) {
+	blob := tryWriteValue([]any{
+		map[string]any{"id": int32(1), "name": "a", "score": 1.5},
+		map[string]any{"id": int32(2), "name": "b", "score": nil},
+		map[string]any{"id": int32(3), "name": "c", "score": 3.5},
+	})
+	cols, err := DecodeColumnar(bytes.NewReader(blob))
+	expect(nil, err)
+	expect(map[string][]any{
+		"id":    {int32(1), int32(2), int32(3)},
+		"name":  {"a", "b", "c"},
+		"score": {1.5, nil, 3.5},
+	}, cols)
+	cols, err = DecodeColumnar(bytes.NewReader(tryWriteValue([]any{})))
+	expect(nil, err)
+	expect(map[string][]any{}, cols)
+	for _, c := range []struct {
+		v    any
+		want string
+	}{
+		{map[string]any{}, "serde.DecodeColumnar: array expected, have object"},
+		{[]any{map[string]any{}, "x"}, "serde.DecodeColumnar: element 1 is not an object"},
+		{[]any{map[string]any{"a": nil}, map[string]any{"a": nil, "b": nil}},
+			`serde.DecodeColumnar: element 1 has property "b", element 0 doesn't`},
+		{[]any{map[string]any{"a": nil, "b": nil, "c": nil}, map[string]any{"c": nil}},
+			`serde.DecodeColumnar: element 1 lacks property "a"`},
+	} {
+		_, err := DecodeColumnar(bytes.NewReader(tryWriteValue(c.v)))
+		expect(c.want, err.Error())
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Golden blobs for what JS_WriteObject() produces for the JS expression in
+// the comment, derived by hand from the serializer in quickjs.c. They were
+// not captured from a QuickJS build; testdata/goldens.js prints the real
+// ones, replace them with its output when it can be run. Every
+// value here is written byte for byte identical by WriteValue and decoded
+// back to the Go value. See the README for intentional divergences.
+var goldens = []struct {
+	js   string
+	v    any
+	blob []byte
+}{
+	{"null", nil, []byte{0, 0x01}},
+	{"undefined", Undefined, []byte{0, 0x02}},
+	{"false", false, []byte{0, 0x03}},
+	{"true", true, []byte{0, 0x04}},
+	{"42", int32(42), []byte{0, 0x05, 0x54}},
+	{"-1", int32(-1), []byte{0, 0x05, 0x01}},
+	{"-2147483648", int32(-2147483648), []byte{0, 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f}},
+	{"1.5", 1.5, []byte{0, 0x06, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+	{"-0", math.Copysign(0, -1), []byte{0, 0x06, 0, 0, 0, 0, 0, 0, 0, 0x80}},
+	{`"ok"`, "ok", []byte{0, 0x07, 0x04, 'o', 'k'}},
+	{`"😭"`, "😭", []byte{0, 0x07, 0x05, 0x3d, 0xd8, 0x2d, 0xde}},
+	{`[1, "a", null]`, []any{int32(1), "a", nil},
+		[]byte{0, 0x09, 0x03, 0x05, 0x02, 0x07, 0x02, 'a', 0x01}},
+	{`{a: 1, b: "x"}`, map[string]any{"a": int32(1), "b": "x"},
+		[]byte{2, 0x02, 'a', 0x02, 'b', 0x08, 0x02, 0x02, 0x05, 0x02, 0x04, 0x07, 0x02, 'x'}},
+	// atoms are numbered in order of first use, nested objects included
+	{`{x: {y: 1}, y: 2}`, map[string]any{"x": map[string]any{"y": int32(1)}, "y": int32(2)},
+		[]byte{2, 0x02, 'x', 0x02, 'y', 0x08, 0x02, 0x02, 0x08, 0x01, 0x04, 0x05, 0x02, 0x04, 0x05, 0x04}},
+	// array index keys are tagged ints, not atoms
+	{`{0: true, 10: false}`, map[string]any{"0": true, "10": false},
+		[]byte{0, 0x08, 0x02, 0x01, 0x04, 0x15, 0x03}},
+	{"new Uint8Array([1, 2])", []byte{1, 2},
+		[]byte{0, 0x0e, 0x02, 0x02, 0x00, 0x0f, 0x02, 0x01, 0x02}},
+	{"new Int16Array([1, -1])", []int16{1, -1},
+		[]byte{0, 0x0e, 0x03, 0x02, 0x00, 0x0f, 0x04, 0x01, 0x00, 0xff, 0xff}},
+	{"new Float64Array([1.5])", []float64{1.5},
+		[]byte{0, 0x0e, 0x0a, 0x01, 0x00, 0x0f, 0x08, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+	{"new Uint8ClampedArray([255])", Uint8ClampedArray{[]byte{255}},
+		[]byte{0, 0x0e, 0x00, 0x01, 0x00, 0x0f, 0x01, 0xff}},
+	{"new ArrayBuffer(2)", ArrayBuffer{[]byte{0, 0}}, []byte{0, 0x0f, 0x02, 0x00, 0x00}},
+	{"Object(true)", BoxedBool{true}, []byte{0, 0x13, 0x04}},
+	{`new String("s")`, BoxedString{"s"}, []byte{0, 0x13, 0x07, 0x02, 's'}},
+	{"-129n", big.NewInt(-129), []byte{0, 0x0a, 0x02, 0x7f, 0xff}},
+	{"new Date(1)", time.UnixMilli(1).UTC(), []byte{0, 0x12, 0x06, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+}
+
+func TestQuickJSCompat(t *testing.T) {
+	for _, g := range goldens {
+		blob := append([]byte{bcVersion}, g.blob...)
+		if have := tryWriteValue(g.v); !bytes.Equal(blob, have) {
+			t.Errorf("%s: expected %v, have %v", g.js, blob, have)
+		}
+		want := g.v
+		if ab, ok := want.(ArrayBuffer); ok {
+			want = ab.Bytes // arraybuffers decode as byte slices
+		}
+		expect(want, tryReadValue(blob))
+	}
+}
+
+// TestMixedRoundTrip checks that a realistic object with values of every
+// supported kind re-encodes to the same bytes after decoding. Caches key
+// on the encoded bytes, so a codec that isn't stable breaks them.
+func TestMixedRoundTrip(t *testing.T) {
+	v := map[string]any{
+		"id":      int32(7),
+		"name":    "sensor ☃",
+		"ratio":   0.25,
+		"big":     big.NewInt(-1 << 62),
+		"created": time.Date(1969, 7, 20, 20, 17, 40, 500000000, time.UTC),
+		"invalid": InvalidDate{},
+		"flags":   []any{true, false, nil, Undefined},
+		"samples": []float32{1.5, -2, float32(math.Inf(1))},
+		"counts":  []uint16{0, 1, 65535},
+		"ids":     []int64{-1 << 63, 1<<63 - 1},
+		"pixels":  Clamped([]byte{0, 128, 255}),
+		"raw":     ArrayBuffer{[]byte{1, 2, 3}},
+		"bytes":   []byte{4, 5},
+		"boxed":   []any{BoxedBool{true}, BoxedNumber{-0.5}, BoxedString{""}},
+		// sparse arrays in QuickJS are objects with index keys
+		"sparse": map[string]any{"0": "a", "1000": "b", "length": int32(1001)},
+		"nested": map[string]any{
+			"when":  []any{time.UnixMilli(-62135596800000).UTC(), time.UnixMilli(8.64e15).UTC()},
+			"data":  map[string]any{"f64": []float64{math.MaxFloat64, math.SmallestNonzeroFloat64}, "i8": []int8{-128, 127}},
+			"empty": map[string]any{},
+			"list":  []any{[]any{}, map[string]any{"x": []uint32{1 << 31}}},
+		},
+	}
+	blob := tryWriteValue(v)
+	d := NewDecoder(bytes.NewReader(blob))
+	d.WrapArrayBuffers = true
+	have, err := d.Decode()
+	expect(nil, err)
+	expect(v, have)
+	expect(blob, tryWriteValue(have))
+	canonical, err := Canonicalize(blob)
+	expect(nil, err)
+	expect(blob, canonical)
+	expect(nil, Validate(bytes.NewReader(blob)))
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dump returns a human-readable rendering of a blob, one value per line
+// and indented by nesting depth, for logs and test failures. The format
+// is not stable. When the blob is malformed, Dump returns the lines
+// rendered so far together with the error.
+func Dump(data []byte) (s string, err error) {
+	var b strings.Builder
+	defer func() { s = b.String() }()
+	defer recoverError(&err, "serde.Dump")
+	br := bytes.NewReader(data)
+	d := NewDecoder(&countingReader{r: br})
+	d.readHeader()
+	fmt.Fprintf(&b, "version %d, %d atoms\n", bcVersion, len(d.atoms))
+	d.dump(&b, 0, "")
+	if br.Len() > 0 {
+		panic(fmt.Sprintf("%d bytes of trailing data", br.Len()))
+	}
+	return
+}
+
+// dump reads one value and writes it to b. Objects are labeled with the
+// number that object references use.
+func (d *Decoder) dump(b *strings.Builder, depth int, prefix string) {
+	r := d.r
+	tag := readByte(r)
+	fmt.Fprintf(b, "%s%s%s", strings.Repeat("  ", depth), prefix, TagName(tag))
+	switch tag {
+	case tagNull, tagUndefined, tagFalse, tagTrue:
+		b.WriteString("\n")
+	case tagInt32:
+		v, err := binary.ReadVarint(byteReader{r})
+		panicIf(err)
+		fmt.Fprintf(b, " %d\n", v)
+	case tagFloat64:
+		v := math.Float64frombits(d.order().Uint64(readBytes(r, 8)))
+		fmt.Fprintf(b, " %s\n", numberString(reflect.ValueOf(v)))
+	case tagString:
+		fmt.Fprintf(b, " %s\n", strconv.Quote(d.readString()))
+	case tagBigInt:
+		fmt.Fprintf(b, " %s\n", readBigInt(r))
+	case tagObject:
+		n := d.readPropertyCount()
+		fmt.Fprintf(b, " #%d, %d properties\n", d.addRef(incomplete{}), n)
+		for i := 0; i < n; i++ {
+			d.dump(b, depth+1, strconv.Quote(d.readAtom())+": ")
+		}
+	case tagArray:
+		n := readUint32(r)
+		fmt.Fprintf(b, " #%d, %d elements\n", d.addRef(incomplete{}), n)
+		for i := 0; i < n; i++ {
+			d.dump(b, depth+1, fmt.Sprintf("[%d] ", i))
+		}
+	case tagArrayBuffer:
+		n := readUint32(r)
+		buf := readBytes(r, n)
+		fmt.Fprintf(b, " #%d, %d bytes % x\n", d.addRef(ArrayBuffer{buf}), n, buf)
+	case tagRegExp:
+		v := d.readRegExp()
+		fmt.Fprintf(b, " #%d, %s\n", d.addRef(v), v)
+	case tagSharedArrayBuffer:
+		v := readSharedArrayBuffer(r, d.order())
+		fmt.Fprintf(b, " #%d, %d bytes, max %d, at %#x\n", d.addRef(v), v.ByteLength, v.MaxByteLength, v.Addr)
+	case tagTypedArray:
+		idx := d.addRef(incomplete{})
+		kind := readByte(r)
+		v := d.readTypedArray(kind)
+		if u, ok := v.(Uint8ClampedArray); ok {
+			v = u.Bytes
+		}
+		fmt.Fprintf(b, " #%d, %s %v\n", idx, TypedArrayKindName(kind), v)
+	case tagObjectValue, tagDate:
+		b.WriteString("\n")
+		d.dump(b, depth+1, "")
+		d.addRef(incomplete{})
+	case tagObjectReference:
+		fmt.Fprintf(b, " #%d\n", readUint32(r))
+	default:
+		b.WriteString("\n")
+		panic(fmt.Sprintf("unsupported %s", TagName(tag)))
+	}
+}
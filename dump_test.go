@@ -0,0 +1,52 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	v := map[string]any{
+		"a": []any{int32(1), "x", nil},
+		"b": map[string]any{"c": 1.5, "d": []int16{1, 2}},
+		"e": BoxedBool{true},
+	}
+	s, err := Dump(tryWriteValue(v))
+	expect(nil, err)
+	want := `version 12, 5 atoms
+object #0, 3 properties
+  "a": array #1, 3 elements
+    [0] int32 1
+    [1] string "x"
+    [2] null
+  "b": object #2, 2 properties
+    "c": float64 1.5
+    "d": typed array #3, Int16Array [1 2]
+  "e": object value
+    true
+`
+	expect(want, s)
+}
+
+func TestDumpError(t *testing.T) {
+	blob := tryWriteValue([]any{"x", Undefined})
+	s, err := Dump(blob[:len(blob)-1])
+	expect("EOF", err.Error())
+	expect(true, strings.HasSuffix(s, "[0] string \"x\"\n"))
+	_, err = Dump(append(blob, 0))
+	expect("serde.Dump: 1 bytes of trailing data", err.Error())
+}
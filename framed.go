@@ -0,0 +1,70 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FramedDecoder reads blobs that are each prefixed with their length as
+// a 32-bit integer. The Decoder's options apply to each blob.
+type FramedDecoder struct {
+	Decoder
+	src   io.Reader
+	order binary.ByteOrder
+}
+
+func NewFramedDecoder(r io.Reader, order binary.ByteOrder) *FramedDecoder {
+	return &FramedDecoder{src: r, order: order}
+}
+
+// Decode reads the next frame. It returns io.EOF when there are no more
+// frames. A blob that is shorter or longer than its frame, or that is
+// malformed, is an error. The rest of the frame is skipped in that case,
+// so that the next call to Decode starts at the next frame.
+func (f *FramedDecoder) Decode() (any, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(f.src, b[:]); err != nil {
+		return nil, err
+	}
+	n := f.order.Uint32(b[:])
+	lr := &io.LimitedReader{R: f.src, N: int64(n)}
+	f.r = lr
+	v, err := f.Decoder.Decode()
+	f.r = nil
+	if err != nil {
+		if lr.N == 0 && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil, fmt.Errorf("serde.FramedDecoder: blob overruns %d byte frame", n)
+		}
+		if _, cerr := io.Copy(io.Discard, lr); cerr != nil {
+			return nil, cerr
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // the frame header was read
+		}
+		return nil, err
+	}
+	if lr.N > 0 {
+		rest := lr.N
+		if _, err := io.Copy(io.Discard, lr); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("serde.FramedDecoder: blob underruns %d byte frame by %d bytes", n, rest)
+	}
+	return v, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func frame(order binary.ByteOrder, n int, blob []byte) []byte {
+	b := make([]byte, 4)
+	order.PutUint32(b, uint32(n))
+	return append(b, blob...)
+}
+
+func TestFramedDecoder(t *testing.T) {
+	a := tryWriteValue("a")
+	b := tryWriteValue(map[string]any{"k": int32(1)})
+	data := append(frame(binary.BigEndian, len(a), a), frame(binary.BigEndian, len(b), b)...)
+	f := NewFramedDecoder(bytes.NewReader(data), binary.BigEndian)
+	v, err := f.Decode()
+	expect(nil, err)
+	expect("a", v)
+	v, err = f.Decode()
+	expect(nil, err)
+	expect(map[string]any{"k": int32(1)}, v)
+	_, err = f.Decode()
+	expect(io.EOF, err)
+
+	// frame too short for the blob, then a good frame
+	data = append(frame(binary.LittleEndian, len(a)-1, a[:len(a)-1]), frame(binary.LittleEndian, len(a), a)...)
+	f = NewFramedDecoder(bytes.NewReader(data), binary.LittleEndian)
+	_, err = f.Decode()
+	expect("serde.FramedDecoder: blob overruns 4 byte frame", err.Error())
+	// frame too long for the blob, then a good frame
+	data = append(frame(binary.LittleEndian, len(a)+2, append(a, 0, 0)), frame(binary.LittleEndian, len(a), a)...)
+	f = NewFramedDecoder(bytes.NewReader(data), binary.LittleEndian)
+	_, err = f.Decode()
+	expect("serde.FramedDecoder: blob underruns 7 byte frame by 2 bytes", err.Error())
+	v, err = f.Decode()
+	expect(nil, err)
+	expect("a", v)
+	// frame ends in the middle of a string or typed array, then a good frame
+	for _, blob := range [][]byte{tryWriteValue("abc"), tryWriteValue([]float64{1})} {
+		data = append(frame(binary.LittleEndian, len(blob)-2, blob[:len(blob)-2]), frame(binary.LittleEndian, len(a), a)...)
+		f = NewFramedDecoder(bytes.NewReader(data), binary.LittleEndian)
+		_, err = f.Decode()
+		expect(fmt.Sprintf("serde.FramedDecoder: blob overruns %d byte frame", len(blob)-2), err.Error())
+		v, err = f.Decode()
+		expect(nil, err)
+		expect("a", v)
+	}
+	// malformed blob, then a good frame
+	bad := []byte{bcVersion, 0, 0xEE, 1, 2, 3}
+	data = append(frame(binary.LittleEndian, len(bad), bad), frame(binary.LittleEndian, len(a), a)...)
+	f = NewFramedDecoder(bytes.NewReader(data), binary.LittleEndian)
+	_, err = f.Decode()
+	expect("serde.ReadValue: unsupported unknown tag 238", err.Error())
+	v, err = f.Decode()
+	expect(nil, err)
+	expect("a", v)
+	// stream ends in the middle of a frame
+	f = NewFramedDecoder(bytes.NewReader(frame(binary.LittleEndian, len(a), a[:2])), binary.LittleEndian)
+	_, err = f.Decode()
+	expect(io.ErrUnexpectedEOF, err)
+	// decoder options apply
+	f = NewFramedDecoder(bytes.NewReader(frame(binary.LittleEndian, len(b), b)), binary.LittleEndian)
+	f.OrderedObjects = true
+	v, err = f.Decode()
+	expect(nil, err)
+	expect(&Object{[]Property{{"k", int32(1)}}}, v)
+}
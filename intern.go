@@ -0,0 +1,117 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"sort"
+	"unsafe"
+)
+
+// intern returns the first object or array that is structurally identical
+// to v, see Decoder.InternObjects, and makes the reference at idx point
+// to it.
+func (d *Decoder) intern(idx int, v any) any {
+	if !d.InternObjects {
+		return v
+	}
+	key, ok := d.internKey(v)
+	if !ok {
+		return v
+	}
+	if w, ok := d.interned[key]; ok {
+		d.refs[idx] = w
+		return w
+	}
+	if d.interned == nil {
+		d.interned = map[string]any{}
+		d.internIDs = map[unsafe.Pointer]bool{}
+	}
+	d.interned[key] = v
+	d.internIDs[identity(v)] = true
+	return v
+}
+
+// internKey encodes the structure of an object or array. Elements that
+// are objects or arrays themselves are encoded by identity, which works
+// because they have been interned already. It returns false when v has
+// elements of other types.
+func (d *Decoder) internKey(v any) (string, bool) {
+	b := []byte{}
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, 'o')
+		for _, k := range keys {
+			b = appendString(b, k)
+			var ok bool
+			if b, ok = d.appendKey(b, t[k]); !ok {
+				return "", false
+			}
+		}
+	case []any:
+		b = append(b, 'a')
+		for _, e := range t {
+			var ok bool
+			if b, ok = d.appendKey(b, e); !ok {
+				return "", false
+			}
+		}
+	default:
+		return "", false
+	}
+	return string(b), true
+}
+
+func (d *Decoder) appendKey(b []byte, v any) ([]byte, bool) {
+	switch t := v.(type) {
+	case nil:
+		return append(b, 'n'), true
+	case UndefinedValue:
+		return append(b, 'u'), true
+	case bool:
+		if t {
+			return append(b, 't'), true
+		}
+		return append(b, 'f'), true
+	case int32:
+		return binary.LittleEndian.AppendUint32(append(b, 'i'), uint32(t)), true
+	case int64:
+		return binary.LittleEndian.AppendUint64(append(b, 'l'), uint64(t)), true
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(b, 'd'), math.Float64bits(t)), true
+	case string:
+		return appendString(append(b, 's'), t), true
+	case Number:
+		return appendString(append(b, 'N'), string(t)), true
+	case *big.Int:
+		return appendString(append(b, 'b'), t.String()), true
+	case map[string]any, []any:
+		if p := identity(t); d.internIDs[p] {
+			return binary.LittleEndian.AppendUint64(append(b, 'p'), uint64(uintptr(p))), true
+		}
+	}
+	return b, false
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
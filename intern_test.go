@@ -0,0 +1,62 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInternObjects(t *testing.T) {
+	leaf := func() map[string]any {
+		return map[string]any{"x": int32(1), "tags": []any{"a", nil, 1.5}}
+	}
+	v := map[string]any{
+		"a":     leaf(),
+		"b":     leaf(),
+		"c":     []any{leaf(), map[string]any{"x": 1.0, "tags": []any{"a", nil, 1.5}}},
+		"typed": []any{[]byte{1}},
+		"typ2":  []any{[]byte{1}},
+	}
+	blob := tryWriteValue(v)
+	have := tryDecode(blob, func(d *Decoder) { d.InternObjects = true }).(map[string]any)
+	expect(v, have)
+	same := func(a, b any) bool {
+		return reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+	}
+	a, b, c := have["a"].(map[string]any), have["b"].(map[string]any), have["c"].([]any)
+	expect(true, same(a, b))
+	expect(true, same(a, c[0]))
+	expect(true, same(a["tags"], c[1].(map[string]any)["tags"]))
+	expect(false, same(a, c[1])) // int32(1) isn't 1.0
+	expect(false, same(have["typed"], have["typ2"]))
+	// off by default
+	have = tryReadValue(blob).(map[string]any)
+	expect(false, same(have["a"], have["b"]))
+	// references to objects that are replaced by their interned copy
+	// o = {}; [{}, o, o]
+	d := NewDecoder(bytes.NewReader([]byte{bcVersion, 0, tagArray, 3, tagObject, 0, tagObject, 0, tagObjectReference, 2}))
+	d.InternObjects = true
+	arr, err := d.Decode()
+	expect(nil, err)
+	expect(true, same(arr.([]any)[0], arr.([]any)[2]))
+	// o = {}; o.o = o
+	d = NewDecoder(bytes.NewReader([]byte{bcVersion, 1, 2, 'o', tagObject, 1, 2, tagObjectReference, 0}))
+	d.InternObjects = true
+	o, err := d.Decode()
+	expect(nil, err)
+	expect(true, same(o, o.(map[string]any)["o"]))
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Get looks up a value in a tree returned by ReadValue or Decode, where
+// objects are map[string]any or *Object. The path is a
+// sequence of property names separated by dots, and array indices in
+// square brackets, e.g. "users[0].name". The empty path returns v.
+func Get(v any, path string) (any, bool) {
+	for path != "" {
+		if path[0] == '[' {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, false
+			}
+			i, err := strconv.Atoi(path[1:end])
+			if err != nil {
+				return nil, false
+			}
+			a, ok := v.([]any)
+			if !ok || i < 0 || i >= len(a) {
+				return nil, false
+			}
+			v = a[i]
+			path = path[end+1:]
+		} else {
+			key := path
+			path = ""
+			if end := strings.IndexAny(key, ".["); end >= 0 {
+				key, path = key[:end], key[end:]
+			}
+			var ok bool
+			switch t := v.(type) {
+			case map[string]any:
+				if v, ok = t[key]; !ok {
+					return nil, false
+				}
+			case *Object:
+				if v, ok = t.Get(key); !ok {
+					return nil, false
+				}
+			default:
+				return nil, false
+			}
+		}
+		if path != "" && path[0] == '.' {
+			path = path[1:]
+		}
+	}
+	return v, true
+}
+
+// GetString also accepts StringBytes, see Decoder.StringsAsBytes.
+func GetString(v any, path string) (string, bool) {
+	switch t := get(v, path).(type) {
+	case string:
+		return t, true
+	case StringBytes:
+		return string(t), true
+	}
+	return "", false
+}
+
+// GetInt also accepts float64 values without a fractional part.
+func GetInt(v any, path string) (int64, bool) {
+	switch t := get(v, path).(type) {
+	case int32:
+		return int64(t), true
+	case float64:
+		if t == math.Trunc(t) && t >= math.MinInt64 && t < math.MaxInt64 {
+			return int64(t), true
+		}
+	}
+	return 0, false
+}
+
+// GetFloat also accepts int32 values.
+func GetFloat(v any, path string) (float64, bool) {
+	switch t := get(v, path).(type) {
+	case int32:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func GetBool(v any, path string) (bool, bool) {
+	b, ok := get(v, path).(bool)
+	return b, ok
+}
+
+func GetArray(v any, path string) ([]any, bool) {
+	a, ok := get(v, path).([]any)
+	return a, ok
+}
+
+func GetObject(v any, path string) (map[string]any, bool) {
+	m, ok := get(v, path).(map[string]any)
+	return m, ok
+}
+
+// AsIntMap converts an object with integer property names, like the ones
+// QuickJS writes as tagged int atoms, to a map with int keys. Names must
+// be in canonical form: "1" and "-1" are integers, "01" and "+1" are not.
+func AsIntMap(m map[string]any) (map[int]any, error) {
+	r := make(map[int]any, len(m))
+	for k, v := range m {
+		n, err := strconv.Atoi(k)
+		if err != nil || strconv.Itoa(n) != k {
+			return nil, fmt.Errorf("serde.AsIntMap: non-integer key %q", k)
+		}
+		r[n] = v
+	}
+	return r, nil
+}
+
+// AsComplex converts an object {re, im}, the way complex numbers are
+// written, back to a complex number.
+func AsComplex(m map[string]any) (complex128, error) {
+	re, ok1 := GetFloat(m, "re")
+	im, ok2 := GetFloat(m, "im")
+	if !ok1 || !ok2 || len(m) != 2 {
+		return 0, fmt.Errorf("serde.AsComplex: not a {re, im} object: %v", m)
+	}
+	return complex(re, im), nil
+}
+
+// get returns nil for missing paths; nil never passes the type assertions
+// in the typed getters.
+func get(v any, path string) any {
+	v, _ = Get(v, path)
+	return v
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	v := tryReadValue(tryWriteValue(map[string]any{
+		"name": "x",
+		"users": []any{
+			map[string]any{"name": "alice", "age": int32(42)},
+			map[string]any{"name": "bob", "tags": []any{"a", "b"}},
+		},
+		"pi": 3.14,
+	}))
+	s, ok := GetString(v, "users[0].name")
+	expect("alice", s)
+	expect(true, ok)
+	s, ok = GetString(v, "users[1].tags[1]")
+	expect("b", s)
+	expect(true, ok)
+	n, ok := GetInt(v, "users[0].age")
+	expect(int64(42), n)
+	expect(true, ok)
+	f, ok := GetFloat(v, "pi")
+	expect(3.14, f)
+	expect(true, ok)
+	a, ok := GetArray(v, "users[1].tags")
+	expect([]any{"a", "b"}, a)
+	expect(true, ok)
+	m, ok := GetObject(v, "users[1]")
+	expect("bob", m["name"])
+	expect(true, ok)
+	_, ok = Get(v, "")
+	expect(true, ok)
+	// strings decoded with StringsAsBytes
+	s, ok = GetString(map[string]any{"k": StringBytes("v")}, "k")
+	expect(true, ok)
+	expect("v", s)
+	// missing paths and type mismatches
+	_, ok = GetString(v, "users[2].name")
+	expect(false, ok)
+	_, ok = GetString(v, "users[0].email")
+	expect(false, ok)
+	_, ok = GetString(v, "users[0].age")
+	expect(false, ok)
+	_, ok = GetInt(v, "pi")
+	expect(false, ok)
+	_, ok = Get(v, "name[0]")
+	expect(false, ok)
+	_, ok = Get(v, "users[x]")
+	expect(false, ok)
+}
+
+func TestAsIntMap(t *testing.T) {
+	v := tryReadValue(tryWriteValue(map[string]any{"0": "a", "42": int32(1), "-1": nil, "4294967296": true}))
+	m, err := AsIntMap(v.(map[string]any))
+	expect(nil, err)
+	expect(map[int]any{0: "a", 42: int32(1), -1: nil, 4294967296: true}, m)
+	for _, k := range []string{"x", "01", "+1", "1.5", ""} {
+		_, err = AsIntMap(map[string]any{"1": nil, k: nil})
+		expect(fmt.Sprintf("serde.AsIntMap: non-integer key %q", k), err.Error())
+	}
+}
+
+func TestAsComplex(t *testing.T) {
+	for _, c := range []complex128{0, 1.5 - 2i, complex(math.Inf(1), math.SmallestNonzeroFloat64)} {
+		v := tryReadValue(tryWriteValue(c))
+		expect(map[string]any{"re": real(c), "im": imag(c)}, v)
+		have, err := AsComplex(v.(map[string]any))
+		expect(nil, err)
+		expect(c, have)
+	}
+	have, err := AsComplex(tryReadValue(tryWriteValue(complex64(0.5i))).(map[string]any))
+	expect(nil, err)
+	expect(0.5i, have)
+	have, err = AsComplex(map[string]any{"re": int32(1), "im": int32(-1)})
+	expect(nil, err)
+	expect(1-1i, have)
+	v := tryReadValue(tryWriteValue(map[string]any{"z": []complex128{1i, 2}})).(map[string]any)
+	have, err = AsComplex(v["z"].([]any)[1].(map[string]any))
+	expect(nil, err)
+	expect(complex(2, 0), have)
+	for _, m := range []map[string]any{{}, {"re": 1.0}, {"re": 1.0, "im": "2"}, {"re": 1.0, "im": 2.0, "x": nil}} {
+		_, err := AsComplex(m)
+		expect(fmt.Sprintf("serde.AsComplex: not a {re, im} object: %v", m), err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// onlyReader hides the io.ByteReader of a *bytes.Reader.
+type onlyReader struct{ io.Reader }
+
+func TestReadPrimitives(t *testing.T) {
+	for _, wrap := range []func([]byte) io.Reader{
+		func(b []byte) io.Reader { return bytes.NewReader(b) },
+		func(b []byte) io.Reader { return onlyReader{bytes.NewReader(b)} },
+	} {
+		b, err := ReadBool(wrap(tryWriteValue(true)))
+		expect(nil, err)
+		expect(true, b)
+		b, err = ReadBool(wrap(tryWriteValue(false)))
+		expect(nil, err)
+		expect(false, b)
+		n, err := ReadInt32(wrap(tryWriteValue(int32(-1 << 31))))
+		expect(nil, err)
+		expect(int32(-1<<31), n)
+		f, err := ReadFloat64(wrap(tryWriteValue(-1.5)))
+		expect(nil, err)
+		expect(-1.5, f)
+		f, err = ReadFloat64(wrap(tryWriteValue(int32(7))))
+		expect(nil, err)
+		expect(7.0, f)
+		s, err := ReadString(wrap(tryWriteValue("☃ ok")))
+		expect(nil, err)
+		expect("☃ ok", s)
+		// atoms are skipped, narrow and wide
+		n, err = ReadInt32(wrap([]byte{bcVersion, 2, 2, 'a', 3, 0x03, 0x26, tagInt32, 4}))
+		expect(nil, err)
+		expect(int32(2), n)
+	}
+	_, err := ReadBool(bytes.NewReader(tryWriteValue(int32(1))))
+	expect("serde.ReadBool: bool expected, have int32", err.Error())
+	_, err = ReadInt32(bytes.NewReader(tryWriteValue(1.5)))
+	expect("serde.ReadInt32: int32 expected, have float64", err.Error())
+	_, err = ReadInt32(bytes.NewReader([]byte{bcVersion, 0, tagInt32, 0xff, 0xff, 0xff, 0xff, 0x7f}))
+	expect("serde.ReadInt32: int32 out of range: -17179869184", err.Error())
+	_, err = ReadFloat64(bytes.NewReader(tryWriteValue("1")))
+	expect("serde.ReadFloat64: float64 expected, have string", err.Error())
+	_, err = ReadFloat64(bytes.NewReader([]byte{bcVersion, 0, tagFloat64, 0}))
+	expect(io.EOF, err)
+	_, err = ReadString(bytes.NewReader(tryWriteValue(nil)))
+	expect("serde.ReadString: string expected, have null", err.Error())
+	_, err = ReadString(bytes.NewReader([]byte{bcVersion - 1, 0, tagNull}))
+	expect("version mismatch (have 11, want 12)", err.Error())
+	r := bytes.NewReader(tryWriteValue(int32(42)))
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Seek(0, io.SeekStart)
+		if n, err := ReadInt32(r); err != nil || n != 42 {
+			t.Fatal(n, err)
+		}
+	})
+	expect(0.0, allocs)
+}
+
+func BenchmarkReadInt32(b *testing.B) {
+	r := bytes.NewReader(tryWriteValue(int32(42)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Seek(0, io.SeekStart)
+		if _, err := ReadInt32(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadValueInt32(b *testing.B) {
+	r := bytes.NewReader(tryWriteValue(int32(42)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Seek(0, io.SeekStart)
+		if _, err := ReadValue(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// RawValue is an encoded value that has not been decoded yet, see
+// Decoder.Lazy. Writing a RawValue splices it into the output as-is,
+// apart from renumbering its atoms. RawValues that contain object
+// references can't be spliced.
+type RawValue struct {
+	data  []byte
+	atoms []string // atom table of the blob data came from
+}
+
+// Bytes returns the encoded value, without header. Atom indices in it
+// refer to the atom table of the blob it came from.
+func (v RawValue) Bytes() []byte {
+	return v.data
+}
+
+// Decode decodes the value with default options. Object references to
+// outside the value can't be resolved.
+func (v RawValue) Decode() (_ any, err error) {
+	defer recoverError(&err, "serde.RawValue.Decode")
+	d := Decoder{r: bytes.NewReader(v.data), atoms: v.atoms}
+	return d.readValue(), nil
+}
+
+func (d *Decoder) readElem() any {
+	if d.lazy {
+		return d.readRaw()
+	}
+	return d.readValue()
+}
+
+func (d *Decoder) readRaw() RawValue {
+	r := d.r
+	buf := bytes.Buffer{}
+	d.r = io.TeeReader(r, &buf)
+	if cr, ok := r.(*countingReader); ok {
+		d.r = &countingReader{d.r, cr.n} // for error offsets
+	}
+	d.copyValue(nil, io.Discard)
+	d.r = r
+	d.shared = true
+	return RawValue{buf.Bytes(), d.atoms}
+}
+
+func (e *Encoder) writeRaw(w io.Writer, v RawValue) {
+	d := Decoder{r: bytes.NewReader(v.data), atoms: v.atoms}
+	d.copyValue(e, w)
+}
+
+// Validate checks that r holds a well-formed blob, as far as ReadValue
+// would check it, without decoding it into Go values. Like ReadValue, it
+// reads one blob and leaves any data after it.
+func Validate(r io.Reader) (err error) {
+	defer recoverError(&err, "serde.Validate")
+	d := NewDecoder(&countingReader{r: r})
+	d.readHeader()
+	d.copyValue(nil, io.Discard)
+	return nil
+}
+
+// copyValue reads one value and writes it to w without decoding it. Atoms
+// are renumbered through e's atom table. A nil e copies them verbatim,
+// which is only useful when the output is discarded, i.e., for skipping
+// and validating.
+func (d *Decoder) copyValue(e *Encoder, w io.Writer) {
+	tag := d.readTag()
+	write(w, []byte{tag})
+	d.copyBody(e, w, tag)
+}
+
+func (d *Decoder) copyBody(e *Encoder, w io.Writer, tag byte) {
+	r := d.r
+	switch tag {
+	case tagNull, tagUndefined, tagFalse, tagTrue:
+	case tagInt32:
+		v, err := binary.ReadVarint(byteReader{r})
+		panicIf(err)
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			panic(fmt.Sprintf("int32 out of range: %d", v))
+		}
+		var b [binary.MaxVarintLen64]byte
+		write(w, b[:binary.PutVarint(b[:], v)])
+	case tagFloat64:
+		copyBytes(w, r, 8)
+	case tagString:
+		n := readUint32(r)
+		writeUvarint(w, n)
+		if n&1 == 1 {
+			copyBytes(w, r, 2*(n>>1))
+		} else {
+			copyBytes(w, r, n>>1)
+		}
+	case tagBigInt:
+		n := readUint32(r)
+		writeUvarint(w, n)
+		copyBytes(w, r, n)
+	case tagObject:
+		n := d.readPropertyCount()
+		writeUvarint(w, n)
+		d.addRef(copied{})
+		for i := 0; i < n; i++ {
+			d.copyAtom(e, w)
+			d.copyValue(e, w)
+		}
+	case tagArray:
+		n := readUint32(r)
+		writeUvarint(w, n)
+		d.addRef(copied{})
+		for i := 0; i < n; i++ {
+			d.copyValue(e, w)
+		}
+	case tagArrayBuffer:
+		n := readUint32(r)
+		writeUvarint(w, n)
+		d.addRef(bufferSize(n))
+		copyBytes(w, r, n)
+	case tagRegExp:
+		d.copyBody(e, w, tagString) // source
+		n := readRegExpBytecodeLen(r)
+		writeUvarint(w, n<<1)
+		copyBytes(w, r, n)
+		d.addRef(copied{})
+	case tagSharedArrayBuffer:
+		v := readSharedArrayBuffer(r, d.order())
+		writeUvarint(w, v.ByteLength)
+		if v.MaxByteLength < 0 {
+			writeUvarint(w, math.MaxUint32)
+		} else {
+			writeUvarint(w, v.MaxByteLength)
+		}
+		var b [8]byte
+		d.order().PutUint64(b[:], v.Addr)
+		write(w, b[:])
+		d.addRef(copied{})
+	case tagTypedArray:
+		kind := readByte(r)
+		n := readUint32(r)
+		offset := readUint32(r)
+		write(w, []byte{kind})
+		writeUvarint(w, n)
+		writeUvarint(w, offset)
+		ref := d.addRef(incomplete{})
+		// inline arraybuffer or reference to a shared one
+		switch tag := readByte(r); tag {
+		case tagArrayBuffer:
+			write(w, []byte{tag})
+			buflen := readUint32(r)
+			checkTypedArrayBounds(kind, n, offset, buflen)
+			writeUvarint(w, buflen)
+			d.addRef(bufferSize(buflen))
+			copyBytes(w, r, buflen)
+		case tagObjectReference:
+			write(w, []byte{tag})
+			if e != nil {
+				panic("cannot splice raw value containing object references")
+			}
+			idx := readUint32(r)
+			if idx >= len(d.refs) {
+				panic(fmt.Sprintf("object reference out of range: %d", idx))
+			}
+			buflen, ok := d.refs[idx].(bufferSize)
+			if !ok {
+				panic(fmt.Sprintf("typed array refers to non-arraybuffer object: %d", idx))
+			}
+			checkTypedArrayBounds(kind, n, offset, int(buflen))
+			writeUvarint(w, idx)
+		default:
+			panic(&TypedArrayBufferError{tag, d.lastOffset()})
+		}
+		d.refs[ref] = copied{}
+	case tagObjectValue:
+		// only primitives can be boxed
+		switch tag := readByte(r); tag {
+		case tagFalse, tagTrue, tagInt32, tagFloat64, tagString:
+			write(w, []byte{tag})
+			d.copyBody(e, w, tag)
+		default:
+			panic(fmt.Sprintf("unsupported boxed %s", TagName(tag)))
+		}
+		d.addRef(copied{})
+	case tagDate:
+		switch tag := readByte(r); tag {
+		case tagInt32, tagFloat64:
+			write(w, []byte{tag})
+			d.copyBody(e, w, tag)
+		default:
+			panic(fmt.Sprintf("unsupported date %s", TagName(tag)))
+		}
+		d.addRef(copied{})
+	case tagObjectReference:
+		if e != nil {
+			panic("cannot splice raw value containing object references")
+		}
+		idx := readUint32(r)
+		if idx >= len(d.refs) {
+			panic(fmt.Sprintf("object reference out of range: %d", idx))
+		}
+		if _, ok := d.refs[idx].(incomplete); ok {
+			panic(fmt.Sprintf("unsupported object reference: %d", idx))
+		}
+		writeUvarint(w, idx)
+	default:
+		panic(fmt.Sprintf("unsupported %s", TagName(tag)))
+	}
+}
+
+// bufferSize stands in for arraybuffers in d.refs while copying, so that
+// typed arrays that refer to one can be checked against its size.
+type bufferSize int
+
+// copied stands in for other objects in d.refs while copying. Like in
+// readBody, objects and arrays can be referred to while their contents
+// are copied, typed arrays only once they are complete.
+type copied struct{}
+
+// copyAtom is like copyValue for property names.
+func (d *Decoder) copyAtom(e *Encoder, w io.Writer) {
+	if e != nil {
+		e.writeAtom(w, d.readAtom())
+		return
+	}
+	idx := readUint32(d.r)
+	if n := idx >> 1; idx&1 == 0 && (n == 0 || n > len(d.atoms)) && !d.LenientAtoms {
+		panic("atom out of range")
+	}
+	writeUvarint(w, idx)
+}
+
+// copyBytes is write(w, readBytes(r, n)) without the intermediate buffer.
+func copyBytes(w io.Writer, r io.Reader, n int) {
+	if _, err := io.CopyN(w, r, int64(n)); err == io.EOF {
+		panic(io.ErrUnexpectedEOF) // the value is incomplete
+	} else if err != nil {
+		panic(err)
+	}
+}
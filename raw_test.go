@@ -0,0 +1,114 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestRawValue(t *testing.T) {
+	blob := tryWriteValue(map[string]any{
+		"a": map[string]any{"x": int32(1), "y": []any{"z", []byte{42}}},
+		"b": "s",
+	})
+	v := tryDecode(blob, func(d *Decoder) { d.Lazy = true }).(map[string]any)
+	a := v["a"].(RawValue)
+	expect(RawValue{[]byte{7, 2, 115}, []string{"a", "x", "y", "b"}}, v["b"])
+	av, err := a.Decode()
+	expect(nil, err)
+	expect(map[string]any{"x": int32(1), "y": []any{"z", []byte{42}}}, av)
+	// re-embed under a different key; atom indices change
+	blob = tryWriteValue(map[string]any{"c": a, "n": int32(7)})
+	expect([]string{"c", "x", "y", "n"}, tryReadAtoms(blob))
+	expect(map[string]any{"c": av, "n": int32(7)}, tryReadValue(blob))
+	// o = {}; [o, o]
+	v2 := tryDecode([]byte{bcVersion, 0, 9, 2, 8, 0, 20, 1}, func(d *Decoder) { d.Lazy = true })
+	err = WriteValue(&bytes.Buffer{}, v2)
+	expect("serde.WriteValue: cannot splice raw value containing object references", err.Error())
+}
+
+func TestValidate(t *testing.T) {
+	valid := [][]byte{
+		tryWriteValue(map[string]any{
+			"a": []any{int32(-1), 1.5, "s", "\u2603", nil, Undefined, true, false},
+			"b": []float64{1, 2},
+			"c": BoxedString{"x"},
+			"d": big.NewInt(-1 << 40),
+			"7": ArrayBuffer{[]byte{1, 2}},
+		}),
+		tryWriteValue(SharedArrayBuffer{8, -1, 42}),
+		// o = {}; [o, o]
+		{bcVersion, 0, tagArray, 2, tagObject, 0, tagObjectReference, 1},
+		// u8 = new Uint8Array(4); [u8, new Uint8Array(u8.buffer, 1, 2)]
+		{bcVersion, 0, tagArray, 2, tagTypedArray, uint8Array, 4, 0, tagArrayBuffer, 4, 0, 0, 0, 0,
+			tagTypedArray, uint8Array, 2, 1, tagObjectReference, 2},
+		// /a/
+		{bcVersion, 0, tagRegExp, 2, 'a', 4, 0, 0},
+	}
+	for _, blob := range valid {
+		expect(nil, Validate(bytes.NewReader(blob)))
+		_, err := ReadValue(bytes.NewReader(blob))
+		expect(nil, err)
+		for i := 0; i < len(blob); i++ {
+			expect(true, Validate(bytes.NewReader(blob[:i])) != nil)
+		}
+	}
+	// a = new Array(5000).fill(null); a[0] = a
+	large := append([]byte{bcVersion, 0, tagArray, 0x88, 0x27, tagObjectReference, 0}, bytes.Repeat([]byte{tagNull}, 4999)...)
+	expect(nil, Validate(bytes.NewReader(large)))
+	_, err := ReadValue(bytes.NewReader(large))
+	expect(nil, err)
+	for _, c := range []struct {
+		blob []byte
+		want string
+	}{
+		{[]byte{bcVersion - 1, 0, tagNull}, "version mismatch (have 11, want 12)"},
+		{[]byte{bcVersion, 0, tagModule}, "serde.Validate: unsupported module"},
+		{[]byte{bcVersion, 0, tagObject, 1, 2, tagNull}, "serde.Validate: atom out of range"},
+		{[]byte{bcVersion, 0, tagArray, 1, tagObjectReference, 1}, "serde.Validate: object reference out of range: 1"},
+		{[]byte{bcVersion, 0, tagInt32, 0xff, 0xff, 0xff, 0xff, 0x7f}, "serde.Validate: int32 out of range: -17179869184"},
+		{[]byte{bcVersion, 0, tagObjectValue, tagNull}, "serde.Validate: unsupported boxed null"},
+		{[]byte{bcVersion, 0, tagTypedArray, int16Array, 2, 0, tagArrayBuffer, 3, 0, 0, 0},
+			"serde.Validate: Int16Array of 2 elements (4 bytes) at offset 0 exceeds arraybuffer of 3 bytes"},
+		{[]byte{bcVersion, 0, tagTypedArray, uint8Array, 0, 0, tagNull}, "typed array not followed by arraybuffer but by null at offset 6"},
+		// [{}, new Uint8Array(<the object>)]
+		{[]byte{bcVersion, 0, tagArray, 2, tagObject, 0, tagTypedArray, uint8Array, 0, 0, tagObjectReference, 1},
+			"serde.Validate: typed array refers to non-arraybuffer object: 1"},
+		// ab = new ArrayBuffer(1); [ab, new Float64Array(ab) with a length of 4]
+		{[]byte{bcVersion, 0, tagArray, 2, tagArrayBuffer, 1, 0, tagTypedArray, float64Array, 4, 0, tagObjectReference, 1},
+			"serde.Validate: Float64Array of 4 elements (32 bytes) at offset 0 exceeds arraybuffer of 1 bytes"},
+		{[]byte{bcVersion, 0, tagString, 5, 'x'}, "unexpected EOF"},
+		{[]byte{bcVersion, 0, tagString, 2}, "unexpected EOF"},
+		{[]byte{bcVersion, 0, tagRegExp, 2, 'a', 5, 0, 0, 0, 0}, "serde.Validate: regexp bytecode is a wide string"},
+		{[]byte{bcVersion, 0, tagRegExp, 2, 'a', 2, 0}, "serde.Validate: regexp bytecode too short"},
+		{[]byte{bcVersion, 0, tagSharedArrayBuffer, 8, 4, 0, 0, 0, 0, 0, 0, 0, 0},
+			"serde.Validate: sharedarraybuffer of 8 bytes has smaller maximum length 4"},
+	} {
+		err := Validate(bytes.NewReader(c.blob))
+		expect(c.want, err.Error())
+		_, err = ReadValue(bytes.NewReader(c.blob))
+		expect(true, err != nil)
+	}
+}
+
+func tryReadAtoms(b []byte) []string {
+	atoms, err := ReadAtoms(bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+	return atoms
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// genValue returns a random value of a type that WriteValue writes and
+// ReadValue reads back as the same Go value. depth bounds the nesting.
+func genValue(rng *rand.Rand, depth int) any {
+	n := 17
+	if depth > 0 {
+		n += 2
+	}
+	switch rng.Intn(n) {
+	case 0:
+		return nil
+	case 1:
+		return Undefined
+	case 2:
+		return rng.Intn(2) == 1
+	case 3:
+		return int32(rng.Uint32())
+	case 4:
+		for {
+			f := math.Float64frombits(rng.Uint64())
+			if !math.IsNaN(f) { // NaN != NaN
+				return f
+			}
+		}
+	case 5:
+		return genString(rng)
+	case 6:
+		b := make([]byte, rng.Intn(8))
+		rng.Read(b)
+		return b
+	case 7:
+		return Uint8ClampedArray{[]byte{byte(rng.Intn(256))}}
+	case 8:
+		return []int8{int8(rng.Uint32()), -128}
+	case 9:
+		return []int16{int16(rng.Uint32())}
+	case 10:
+		return []uint16{uint16(rng.Uint32()), 0}
+	case 11:
+		return []int32{int32(rng.Uint32()), math.MaxInt32}
+	case 12:
+		return []uint32{rng.Uint32()}
+	case 13:
+		return []int64{int64(rng.Uint64())}
+	case 14:
+		return []uint64{rng.Uint64(), math.MaxUint64}
+	case 15:
+		return []float32{rng.Float32(), float32(math.Inf(-1))}
+	case 16:
+		switch rng.Intn(7) {
+		case 0:
+			return []float64{rng.NormFloat64(), math.SmallestNonzeroFloat64}
+		case 1:
+			n := new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), uint(rng.Intn(200)+1)))
+			return n.Add(n, big.NewInt(1)) // a zero from Rand isn't DeepEqual to a decoded one
+		case 2:
+			return new(big.Int).Neg(big.NewInt(rng.Int63()))
+		case 3:
+			return time.UnixMilli(rng.Int63n(8.64e15*2) - 8.64e15).UTC()
+		case 4:
+			return BoxedNumber{rng.NormFloat64()}
+		case 5:
+			return BoxedString{genString(rng)}
+		default:
+			return BoxedBool{rng.Intn(2) == 1}
+		}
+	case 17:
+		a := make([]any, rng.Intn(5))
+		for i := range a {
+			a[i] = genValue(rng, depth-1)
+		}
+		return a
+	default:
+		m := map[string]any{}
+		for i := rng.Intn(5); i > 0; i-- {
+			m[genKey(rng)] = genValue(rng, depth-1)
+		}
+		return m
+	}
+}
+
+func genString(rng *rand.Rand) string {
+	r := make([]rune, rng.Intn(6))
+	for i := range r {
+		switch rng.Intn(4) {
+		case 0:
+			r[i] = rune(rng.Intn(0x80))
+		case 1:
+			r[i] = rune(rng.Intn(0x100))
+		case 2:
+			r[i] = rune(rng.Intn(0xd800))
+		default:
+			r[i] = rune(0x10000 + rng.Intn(0x100000))
+		}
+	}
+	return string(r)
+}
+
+func genKey(rng *rand.Rand) string {
+	switch rng.Intn(4) {
+	case 0:
+		return strconv.Itoa(rng.Intn(1 << 20)) // index keys are tagged ints
+	case 1:
+		return "0" + strconv.Itoa(rng.Intn(100)) // but these aren't
+	default:
+		return genString(rng)
+	}
+}
+
+// TestRoundTripProperty checks that what WriteValue writes, ReadValue
+// reads back as the same value, for random values, also when the blob
+// goes through a pipe, and that the value is then written as the same
+// bytes again.
+func TestRoundTripProperty(t *testing.T) {
+	// the empty string is an empty write, which a pipe passes on as a
+	// read of 0 bytes
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(WriteValue(pw, map[string]any{"": ""})) }()
+	have, err := ReadValue(pr)
+	expect(nil, err)
+	expect(map[string]any{"": ""}, have)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		v := genValue(rng, 4)
+		blob := tryWriteValue(v)
+		have, err = ReadValue(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatalf("%#v: %v", v, err)
+		}
+		expect(v, have)
+		expect(blob, tryWriteValue(have))
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(WriteValue(pw, v)) }()
+		have, err = ReadValue(pr)
+		if err != nil {
+			t.Fatalf("%#v: %v", v, err)
+		}
+		expect(v, have)
+		pr.Close()
+	}
+}
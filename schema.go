@@ -0,0 +1,94 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Schema is a precomputed mapping from property names to the fields of a
+// struct type. ReadObject and friends compile the schema of a struct type
+// the first time they decode into it and reuse it after that. A Schema is
+// safe for concurrent use.
+type Schema struct {
+	typ    reflect.Type
+	fields []reflect.StructField // fields with a property name
+	index  map[string]int        // property name -> first field in fields
+}
+
+var schemas sync.Map // reflect.Type -> *Schema
+
+// CompileSchema returns the Schema for struct type t. It maps property
+// names to fields the same way ReadObject does. It panics if t is not a
+// struct type.
+func CompileSchema(t reflect.Type) *Schema {
+	if s, ok := schemas.Load(t); ok {
+		return s.(*Schema)
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("serde.CompileSchema: %v is not a struct type", t))
+	}
+	s := &Schema{typ: t, index: map[string]int{}}
+	for _, f := range reflect.VisibleFields(t) {
+		name := fieldName(f)
+		if name == "" {
+			continue
+		}
+		// the first field with a given name wins
+		if _, ok := s.index[name]; !ok {
+			s.index[name] = len(s.fields)
+		}
+		s.fields = append(s.fields, f)
+	}
+	v, _ := schemas.LoadOrStore(t, s)
+	return v.(*Schema)
+}
+
+// field looks up the struct field for a property. A field's property
+// name is the name in its `js:"name"` tag, or else the field name. Fields
+// tagged `js:"-"` are ignored. With fold, a case-insensitive match is used
+// when there is no exact match.
+func (s *Schema) field(name string, fold bool) (reflect.StructField, bool) {
+	if i, ok := s.index[name]; ok {
+		return s.fields[i], true
+	}
+	if fold {
+		for _, f := range s.fields {
+			if strings.EqualFold(fieldName(f), name) {
+				return f, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Decode is like ReadObject but dst must be a pointer to the schema's
+// struct type.
+func (s *Schema) Decode(r io.Reader, dst any) error {
+	return NewDecoder(r).DecodeSchema(s, dst)
+}
+
+// DecodeSchema is like DecodeObject but v must be a pointer to the
+// schema's struct type.
+func (d *Decoder) DecodeSchema(s *Schema, v any) (err error) {
+	if t := reflect.TypeOf(v); t != reflect.PointerTo(s.typ) {
+		return fmt.Errorf("serde.Schema.Decode: have %v, want *%v", t, s.typ)
+	}
+	return d.decodeObject(v, "serde.Schema.Decode")
+}
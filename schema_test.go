@@ -0,0 +1,76 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type schemaRecord struct {
+	ID    int
+	Name  string `js:"name"`
+	Score float64
+	Tags  []any
+	skip  bool
+}
+
+var schemaBlob = tryWriteValue(map[string]any{
+	"ID":    int32(7),
+	"name":  "x",
+	"Score": 1.5,
+	"Tags":  []any{"a"},
+	"other": true,
+})
+
+func TestSchema(t *testing.T) {
+	s := CompileSchema(reflect.TypeOf(schemaRecord{}))
+	v := schemaRecord{skip: true}
+	expect(nil, s.Decode(bytes.NewReader(schemaBlob), &v))
+	expect(schemaRecord{7, "x", 1.5, []any{"a"}, true}, v)
+	expect(v, *tryReadObject(&schemaRecord{skip: true}, schemaBlob).(*schemaRecord))
+	err := s.Decode(bytes.NewReader(schemaBlob), &struct{}{})
+	expect("serde.Schema.Decode: have *struct {}, want *serde.schemaRecord", err.Error())
+	expect(s, CompileSchema(reflect.TypeOf(schemaRecord{})))
+	// decoder options apply
+	d := NewDecoder(bytes.NewReader(tryWriteValue(map[string]any{"NAME": "y", "id": int32(8)})))
+	d.CaseInsensitive = true
+	v = schemaRecord{}
+	expect(nil, d.DecodeSchema(s, &v))
+	expect(schemaRecord{ID: 8, Name: "y"}, v)
+	expect(int64(19), d.BytesRead())
+	expect(io.ErrUnexpectedEOF, s.Decode(bytes.NewReader(schemaBlob[:10]), &v))
+}
+
+func BenchmarkSchemaDecode(b *testing.B) {
+	s := CompileSchema(reflect.TypeOf(schemaRecord{}))
+	for i := 0; i < b.N; i++ {
+		v := schemaRecord{}
+		if err := s.Decode(bytes.NewReader(schemaBlob), &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadObject(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		v := schemaRecord{}
+		if err := ReadObject(bytes.NewReader(schemaBlob), &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -15,17 +15,38 @@
 package serde
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 	"unsafe"
 )
 
 const bcVersion = 12
 
+// maxPrealloc caps allocations sized by counts read from the input.
+const maxPrealloc = 4096
+
+func preallocLimit(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
 // corresponds with BCTagEnum in quickjs.c
 const (
 	tagNull = 1 + iota
@@ -64,69 +85,1096 @@ const (
 	float64Array
 )
 
+func typedArrayElementSize(kind byte) int {
+	switch kind {
+	case uint8ClampedArray, int8Array, uint8Array:
+		return 1
+	case int16Array, uint16Array:
+		return 2
+	case int32Array, uint32Array, float32Array:
+		return 4
+	case bigInt64Array, bigUint64Array, float64Array:
+		return 8
+	}
+	panic(fmt.Sprintf("bad typed array tag: %d", kind))
+}
+
 type ArrayBuffer struct{ Bytes []byte }
+
+// Uint8ClampedArray is written with the clamped subtag and reads back as
+// a Uint8ClampedArray, unlike []byte, which is a Uint8Array.
 type Uint8ClampedArray struct{ Bytes []byte }
+
+// Clamped returns b as a Uint8ClampedArray, e.g., for canvas ImageData.
+// It doesn't copy b.
+func Clamped(b []byte) Uint8ClampedArray {
+	return Uint8ClampedArray{b}
+}
+
 type UndefinedValue struct{}
 
+// InvalidDate is a Date whose time value is NaN, i.e., new Date(NaN).
+// Valid Dates are decoded as time.Time in UTC.
+type InvalidDate struct{}
+
+// BufferSource is written as an ArrayBuffer with contents from a reader,
+// see BufferFrom.
+type BufferSource struct {
+	r io.Reader
+	n int
+}
+
+// BufferFrom returns a value that is written as an ArrayBuffer with the
+// next n bytes from r as its contents. They are copied to the output
+// directly, without holding them in memory. It is an error if r has fewer
+// than n bytes, one that leaves the output incomplete. The value can be
+// written only once.
+func BufferFrom(r io.Reader, n int) BufferSource {
+	return BufferSource{r, n}
+}
+
+// SharedArrayBuffer is how QuickJS serializes a SharedArrayBuffer: by the
+// address of its memory, not by its contents, which is only meaningful to
+// the process that wrote it.
+type SharedArrayBuffer struct {
+	ByteLength    int
+	MaxByteLength int // -1 when not growable
+	Addr          uint64
+}
+
+// RegExp is a decoded regular expression. QuickJS serializes it as its
+// source and compiled bytecode; the flags are taken from the bytecode.
+// RegExps can't be written because that needs the QuickJS regexp
+// compiler.
+type RegExp struct {
+	Source      string
+	HasIndices  bool // d
+	Global      bool // g
+	IgnoreCase  bool // i
+	Multiline   bool // m
+	DotAll      bool // s
+	Unicode     bool // u
+	UnicodeSets bool // v
+	Sticky      bool // y
+}
+
+// Flags returns the flags in the order of RegExp.prototype.flags.
+func (re RegExp) Flags() string {
+	b := []byte{}
+	for _, f := range []struct {
+		set bool
+		c   byte
+	}{
+		{re.HasIndices, 'd'}, {re.Global, 'g'}, {re.IgnoreCase, 'i'}, {re.Multiline, 'm'},
+		{re.DotAll, 's'}, {re.Unicode, 'u'}, {re.UnicodeSets, 'v'}, {re.Sticky, 'y'},
+	} {
+		if f.set {
+			b = append(b, f.c)
+		}
+	}
+	return string(b)
+}
+
+func (re RegExp) String() string {
+	return "/" + re.Source + "/" + re.Flags()
+}
+
+// LRE_FLAG_* from libregexp.h
+const (
+	lreFlagGlobal = 1 << iota
+	lreFlagIgnoreCase
+	lreFlagMultiline
+	lreFlagDotAll
+	lreFlagUnicode
+	lreFlagSticky
+	lreFlagIndices
+	lreFlagNamedGroups
+	lreFlagUnicodeSets
+)
+
+// readRegExp reads the source and the bytecode, a narrow string that
+// starts with the 16 bits flags field of the libregexp header.
+func (d *Decoder) readRegExp() RegExp {
+	re := RegExp{Source: d.readString()}
+	bc := readBytes(d.r, readRegExpBytecodeLen(d.r))
+	flags := d.order().Uint16(bc)
+	re.HasIndices = flags&lreFlagIndices != 0
+	re.Global = flags&lreFlagGlobal != 0
+	re.IgnoreCase = flags&lreFlagIgnoreCase != 0
+	re.Multiline = flags&lreFlagMultiline != 0
+	re.DotAll = flags&lreFlagDotAll != 0
+	re.Unicode = flags&lreFlagUnicode != 0
+	re.UnicodeSets = flags&lreFlagUnicodeSets != 0
+	re.Sticky = flags&lreFlagSticky != 0
+	return re
+}
+
+// readRegExpBytecodeLen reads the length of the bytecode of a regexp, a
+// narrow string that starts with the flags.
+func readRegExpBytecodeLen(r io.Reader) int {
+	n := readUint32(r)
+	if n&1 == 1 {
+		panic("regexp bytecode is a wide string")
+	}
+	if n>>1 < 2 {
+		panic("regexp bytecode too short")
+	}
+	return n >> 1
+}
+
+// Runes is written as a string. rune is an alias for int32 so a plain
+// []rune is indistinguishable from []int32 and is written as an
+// Int32Array; likewise a single rune is written as a number.
+type Runes []rune
+
+// StringBytes is a string as UTF-8 bytes, see Decoder.StringsAsBytes. It
+// is written as a string, unlike []byte, which is a Uint8Array.
+type StringBytes []byte
+
+// Object is an object with its properties in order, see
+// Decoder.OrderedObjects. Unlike map[string]any, it is written with its
+// properties in that order.
+//
+// There is no field for the prototype because the format doesn't have
+// one: QuickJS writes an Object.create(null) object exactly like {} with
+// the same properties and reads both back as plain objects.
+type Object struct {
+	Props []Property
+}
+
+type Property struct {
+	Key   string
+	Value any
+}
+
+// Get returns the value of the first property called key.
+func (o *Object) Get(key string) (any, bool) {
+	for _, p := range o.Props {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// BoxedBool, BoxedNumber and BoxedString are primitive wrapper objects,
+// e.g., new Boolean(true) or Object("s").
+type BoxedBool struct{ Value bool }
+type BoxedNumber struct{ Value float64 }
+type BoxedString struct{ Value string }
+
+// Number is a JS number in its textual form, like json.Number. Integers
+// are in decimal notation, other numbers in the shortest representation
+// that round-trips to the same float64.
+type Number string
+
+func (n Number) String() string { return string(n) }
+
+func (n Number) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
+
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
 var Undefined = UndefinedValue{}
 
+// ReadValue decodes a blob. Objects decode as map[string]any and arrays
+// as []any, empty ones too, so that WriteValue writes an empty object back
+// as an object and an empty array as an array.
 func ReadValue(r io.Reader) (v any, err error) {
-	defer func() {
-		if x := recover(); x != nil {
-			switch v := x.(type) {
-			case error:
-				err = v
-			default:
-				err = fmt.Errorf("serde.ReadValue: %v", v)
-			}
-		}
-	}()
-	atoms := readHeader(r)
-	v = readValue(r, atoms)
-	return
+	return NewDecoder(r).Decode()
+}
+
+// ReadValueRaw is like ReadValue and also returns the bytes of the blob,
+// header included, exactly as they were read from r, e.g., to store them
+// verbatim. On error, raw holds the bytes read up to that point.
+func ReadValueRaw(r io.Reader) (v any, raw []byte, err error) {
+	buf := bytes.Buffer{}
+	v, err = ReadValue(io.TeeReader(r, &buf))
+	return v, buf.Bytes(), err
 }
 
 func ReadObject(r io.Reader, v any) (err error) {
-	defer func() {
-		if x := recover(); x != nil {
-			switch v := x.(type) {
-			case error:
-				err = v
-			default:
-				err = fmt.Errorf("serde.ReadObject: %v", v)
-			}
+	return NewDecoder(r).DecodeObject(v)
+}
+
+// ReadObjectPresence is like ReadObject and also returns the names of the
+// properties that matched a field of v, i.e., the fields that were set,
+// also when the value was null or undefined. Only top-level properties are
+// recorded.
+func ReadObjectPresence(r io.Reader, v any) (present map[string]bool, err error) {
+	d := NewDecoder(r)
+	d.present = map[string]bool{}
+	if err := d.DecodeObject(v); err != nil {
+		return nil, err
+	}
+	return d.present, nil
+}
+
+// PatchObject applies an object to the struct v points to, like a partial
+// update. Only properties that are present and not undefined are applied;
+// fields without a property keep their value, also in nested structs. A
+// null property sets its field to the zero value. Maps and slices are
+// replaced, not merged.
+func PatchObject(r io.Reader, v any) error {
+	d := NewDecoder(r)
+	d.patch = true
+	return d.DecodeObject(v)
+}
+
+// ReadAtoms reads just the header and returns the atom table, i.e., the
+// property names used by the value. The reader is left positioned at the
+// first value; the value itself is not consumed.
+func ReadAtoms(r io.Reader) (atoms []string, err error) {
+	defer recoverError(&err, "serde.ReadAtoms")
+	d := NewDecoder(r)
+	d.readHeader()
+	return d.atoms, nil
+}
+
+// RenameAtoms returns a copy of data with the atoms in mapping renamed,
+// e.g., to anonymize property names. Only the atom table in the header is
+// rewritten; the value is copied as-is because it refers to atoms by
+// index. Array indices and other integer property names aren't atoms and
+// can't be renamed. Renaming two atoms to the same name makes objects
+// that have both properties decode with a duplicate property.
+func RenameAtoms(data []byte, mapping map[string]string) (blob []byte, err error) {
+	defer recoverError(&err, "serde.RenameAtoms")
+	br := bytes.NewReader(data)
+	d := NewDecoder(br)
+	offset := func() int { return len(data) - br.Len() }
+	if version := readByte(br); version != bcVersion {
+		panic(&VersionMismatchError{version, bcVersion})
+	}
+	count := readUint32(br)
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	buf.Write(data[:offset()])
+	for i := 0; i < count; i++ {
+		start := offset()
+		name, ok := mapping[d.readString()]
+		if ok {
+			writeString(buf, name)
+		} else {
+			buf.Write(data[start:offset()])
 		}
-	}()
-	atoms := readHeader(r)
-	if tag := readByte(r); tag != tagObject {
-		panic(fmt.Sprintf("object expected, have %s", tagName(tag)))
 	}
-	count := readUint32(r) // property count
+	buf.Write(data[offset():])
+	return buf.Bytes(), nil
+}
+
+// Info describes a blob's top-level value, see Inspect.
+type Info struct {
+	Tag byte // see TagName
+	// Count is the number of properties of an object, the number of
+	// elements of an array or typed array, the number of bytes of an
+	// arraybuffer, the number of characters of a string, and zero for
+	// other values.
+	Count     int
+	AtomCount int
+}
+
+// Inspect reads the header and the start of the top-level value, just
+// enough to fill in an Info. The rest of the value is not consumed.
+func Inspect(r io.Reader) (info Info, err error) {
+	defer recoverError(&err, "serde.Inspect")
+	d := NewDecoder(r)
+	d.readHeader()
+	info.AtomCount = len(d.atoms)
+	info.Tag = readByte(r)
+	switch info.Tag {
+	case tagObject:
+		info.Count = d.readPropertyCount()
+	case tagArray, tagArrayBuffer:
+		info.Count = readUint32(r)
+	case tagString:
+		info.Count = readUint32(r) >> 1
+	case tagTypedArray:
+		readByte(r) // kind
+		info.Count = readUint32(r)
+	}
+	return info, nil
+}
+
+// ReadValues reads blobs until r is exhausted. It is an error if r ends
+// in the middle of a blob.
+func ReadValues(r io.Reader) ([]any, error) {
+	vs := []any{}
+	for {
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err == io.EOF {
+			return vs, nil
+		} else if err != nil {
+			return vs, err
+		}
+		v, err := ReadValue(io.MultiReader(bytes.NewReader(b[:]), r))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return vs, err
+		}
+		vs = append(vs, v)
+	}
+}
+
+// ReadValueN reads a blob that is exactly n bytes long. It is an error if
+// the value ends before or extends past the nth byte. r is always advanced
+// by n bytes, or to its end if it is shorter, so that a caller can move on
+// to the next blob after an error. It returns io.EOF if r is empty.
+func ReadValueN(r io.Reader, n int) (any, error) {
+	lr := &io.LimitedReader{R: r, N: int64(n)}
+	v, err := ReadValue(lr)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if lr.N == 0 {
+			err = fmt.Errorf("serde.ReadValueN: value is longer than %d bytes", n)
+		} else if lr.N < int64(n) && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err == nil && lr.N > 0 {
+		err = fmt.Errorf("serde.ReadValueN: value is %d bytes, expected %d", int64(n)-lr.N, n)
+	}
+	if _, cerr := io.Copy(io.Discard, lr); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Canonicalize re-encodes a blob in a canonical form: object properties
+// sorted by name, integral numbers as int32 where possible, and only the
+// atoms that are used. Narrow strings are read as latin1, like QuickJS
+// writes them, and non-ASCII strings are written as UTF-16. Equal values
+// canonicalize to the same bytes, except that shared objects are expanded
+// into copies and cycles are rejected.
+func Canonicalize(data []byte) ([]byte, error) {
+	br := bytes.NewReader(data)
+	d := NewDecoder(br)
+	d.WrapArrayBuffers = true
+	d.Latin1 = true
+	v, err := d.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if br.Len() > 0 {
+		return nil, fmt.Errorf("serde.Canonicalize: %d bytes of trailing data", br.Len())
+	}
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	e.narrow = true
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decoder reads values from an input stream. The zero value of each
+// option field selects the default behavior of ReadValue and ReadObject.
+//
+// A Decoder is not safe for concurrent use but the package-level functions
+// are. Use GetDecoder and PutDecoder to recycle decoders across goroutines.
+type Decoder struct {
+	// Latin1 interprets narrow strings as latin1, i.e., each byte is
+	// one code point, like QuickJS does. The default is to treat them
+	// as raw bytes. The two differ for bytes >= 0x80.
+	Latin1 bool
+
+	// UseNumber decodes int32 and float64 values as a Number instead
+	// of as an int32 or float64. DecodeObject converts them back for
+	// numeric fields.
+	UseNumber bool
+
+	// UTF8Buffers decodes ArrayBuffers and Uint8Arrays as strings
+	// instead of byte slices when their contents are valid UTF-8.
+	UTF8Buffers bool
+
+	// Hooks are called at various points while decoding.
+	Hooks DecodeHooks
+
+	// CaseInsensitive makes DecodeObject fall back to matching property
+	// names to struct fields case-insensitively when there is no exact
+	// match, like encoding/json.
+	CaseInsensitive bool
+
+	// MaxObjectProperties limits the number of properties an object
+	// can declare. Zero means no limit.
+	MaxObjectProperties int
+
+	// MaxElements limits the total number of array elements and object
+	// properties in a blob, e.g., to bound the work done for a huge but
+	// well-formed array. Zero means no limit.
+	MaxElements int
+
+	// MaxTypedArrayBytes limits the total size of the typed arrays and
+	// arraybuffers in a blob, checked before the memory for each one is
+	// allocated. Typed arrays that share an arraybuffer count once. Zero
+	// means no limit.
+	MaxTypedArrayBytes int
+
+	// OrderedObjects decodes objects as *Object, which keeps properties
+	// in the order they appear in the input. Together with
+	// WrapArrayBuffers, decoded values re-encode to the exact input bytes,
+	// provided that strings are ASCII or not latin1.
+	OrderedObjects bool
+
+	// WrapArrayBuffers decodes ArrayBuffers as ArrayBuffer instead of
+	// []byte, which is also how Uint8Arrays are decoded.
+	WrapArrayBuffers bool
+
+	// NarrowFloats decodes float64 values that are integral and fit in
+	// an int32 as int32, like QuickJS would have written them. Negative
+	// zero stays a float64.
+	NarrowFloats bool
+
+	// BigIntAsInt64 decodes BigInts that fit in 64 bits as int64
+	// instead of as *big.Int.
+	BigIntAsInt64 bool
+
+	// BigIntArrays decodes BigInt64Arrays and BigUint64Arrays as
+	// []*big.Int instead of as []int64 and []uint64, the way BigInts are
+	// decoded. The slice doesn't share memory with the arraybuffer.
+	BigIntArrays bool
+
+	// Intern is called with every decoded string value and returns the
+	// string to use in its place, typically an equal string seen before
+	// so that repeated values share memory. Property names are already
+	// shared within a blob and are not passed to Intern.
+	Intern func(s string) string
+
+	// LenientAtoms decodes references to atoms that aren't in the atom
+	// table as a placeholder "<atom N>" instead of failing.
+	LenientAtoms bool
+
+	// StreamBuffers makes Decode return an *ArrayBufferReader, without
+	// reading the contents, when the top-level value is an ArrayBuffer.
+	// The contents must be read in full before d is used again. Nested
+	// ArrayBuffers are decoded as usual because the decoder has to read
+	// past them.
+	StreamBuffers bool
+
+	// AllowedTags restricts the kinds of values in the input to the
+	// tags with these names, see TagName, e.g., "object", "array",
+	// "string", "int32" and "float64" for plain data. Decode fails at
+	// the first value with another tag. Nil allows everything.
+	AllowedTags []string
+
+	// ByteOrder is the byte order of the multi-byte numbers in the
+	// input: float64 values, wide string characters, typed array
+	// elements and the like. QuickJS always writes little-endian, which
+	// is what nil means, but patched big-endian builds may not. Typed
+	// arrays in a byte order other than the host's are copies, they
+	// don't share memory with their arraybuffer.
+	ByteOrder binary.ByteOrder
+
+	// InternObjects makes Decode return the same map or slice for
+	// objects and arrays that are structurally identical, to save
+	// memory when the input repeats subtrees. The result must be treated
+	// as read-only. Only objects and arrays that consist of primitives
+	// and other interned objects and arrays are interned, and not at all
+	// with OrderedObjects.
+	InternObjects bool
+
+	// Lazy makes Decode return the elements of a top-level array or the
+	// property values of a top-level object as RawValues. It has no
+	// effect on DecodeObject.
+	Lazy bool
+
+	// CollectWarnings records problems that Decode and DecodeObject can
+	// recover from, instead of ignoring them or failing, see Warnings.
+	// They are: duplicate property names, where the last value wins;
+	// floating-point numbers stored in integer fields; and
+	// values that can't be stored in a struct field, which is then left
+	// unchanged. Unknown tags stay fatal: the format isn't self-delimiting,
+	// so there is no way to skip over what follows them.
+	CollectWarnings bool
+
+	// StringsAsBytes decodes strings as StringBytes instead of as
+	// strings, which saves a copy of each ASCII string, e.g., for values
+	// that are only passed through. Other strings are converted to
+	// UTF-8; narrow strings are read as latin1 regardless of Latin1.
+	// Property names, the strings in boxed strings and regexp sources are
+	// still strings, and Intern isn't called.
+	StringsAsBytes bool
+
+	// NumberHook is called with every int32 and float64 value and its
+	// tag, see TagName, and returns the value to use in its place, e.g.,
+	// a decimal type. UseNumber and NarrowFloats don't apply to numbers
+	// that are passed to NumberHook. The numbers inside boxed numbers
+	// and dates aren't passed to it. An error stops decoding.
+	NumberHook func(tag byte, raw any) (any, error)
+
+	// Enums lists the valid values of string types, typically enums,
+	// e.g., reflect.TypeOf(State("")). DecodeObject fails when a string
+	// that isn't one of them is stored in a field of such a type, or in
+	// a slice element or map value of that type.
+	Enums map[reflect.Type][]string
+
+	lazy      bool
+	shared    bool            // d.atoms is held by RawValues, see readRaw
+	wrapped   bool            // see readWrapped
+	patch     bool            // skip undefined properties, see PatchObject
+	present   map[string]bool // see ReadObjectPresence
+	warnings  []Warning
+	elements  int            // see MaxElements
+	buffers   int            // see MaxTypedArrayBytes
+	allowed   *[256]bool     // see AllowedTags
+	interned  map[string]any // see InternObjects
+	internIDs map[unsafe.Pointer]bool
+	key       string // property being decoded
+	bytesRead int64
+
+	r     io.Reader
+	atoms []string
+	refs  []any // see addRef
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Warning is a problem that the decoder recovered from, see
+// Decoder.CollectWarnings.
+type Warning struct {
+	Offset  int64 // in the input where the problem was noticed, -1 if unknown
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s at offset %d", w.Message, w.Offset)
+}
+
+// Warnings returns the warnings of the last call to Decode or
+// DecodeObject.
+func (d *Decoder) Warnings() []Warning {
+	return d.warnings
+}
+
+func (d *Decoder) warn(format string, args ...any) {
+	d.warnings = append(d.warnings, Warning{d.lastOffset(), fmt.Sprintf(format, args...)})
+}
+
+// Reset makes d read from r and restores the default options. Internal
+// buffers are kept for reuse.
+func (d *Decoder) Reset(r io.Reader) {
+	// don't pin strings and objects from the previous input
+	atoms := d.atoms[:cap(d.atoms)]
+	if d.shared {
+		atoms = nil
+	}
+	for i := range atoms {
+		atoms[i] = ""
+	}
+	refs := d.refs[:cap(d.refs)]
+	for i := range refs {
+		refs[i] = nil
+	}
+	*d = Decoder{r: r, atoms: atoms[:0], refs: refs[:0]}
+}
+
+var decoderPool = sync.Pool{New: func() any { return new(Decoder) }}
+
+// GetDecoder returns a Decoder with default options that reads from r,
+// taken from a pool shared by all goroutines. Return it with PutDecoder.
+func GetDecoder(r io.Reader) *Decoder {
+	d := decoderPool.Get().(*Decoder)
+	d.Reset(r)
+	return d
+}
+
+// PutDecoder returns d to the pool. d must not be used afterwards.
+func PutDecoder(d *Decoder) {
+	d.Reset(nil) // don't keep the last input's values alive in the pool
+	decoderPool.Put(d)
+}
+
+func (d *Decoder) Decode() (v any, err error) {
+	o := d.observe()
+	defer func() { o.end(err) }()
+	defer recoverError(&err, "serde.ReadValue")
+	d.readHeader()
+	o.header()
+	d.lazy = d.Lazy
+	if tag := d.readTag(); tag == tagArrayBuffer && d.StreamBuffers {
+		n := readUint32(d.r)
+		v = &ArrayBufferReader{n, io.LimitedReader{R: d.r, N: int64(n)}}
+	} else {
+		v = d.readBody(tag)
+	}
+	o.value(v)
+	return
+}
+
+// ArrayBufferReader reads the contents of a top-level ArrayBuffer from the
+// decoder's input, see Decoder.StreamBuffers.
+type ArrayBufferReader struct {
+	ByteLength int
+	r          io.LimitedReader
+}
+
+// Read returns io.ErrUnexpectedEOF if the input ends before ByteLength
+// bytes have been read.
+func (ab *ArrayBufferReader) Read(p []byte) (int, error) {
+	n, err := ab.r.Read(p)
+	if err == io.EOF && ab.r.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (d *Decoder) DecodeObject(v any) (err error) {
+	fn := "serde.ReadObject"
+	if d.patch {
+		fn = "serde.PatchObject"
+	}
+	return d.decodeObject(v, fn)
+}
+
+func (d *Decoder) decodeObject(v any, fn string) (err error) {
+	o := d.observe()
+	defer func() { o.end(err) }()
+	defer recoverError(&err, fn)
+	d.readHeader()
+	o.header()
+	d.lazy = false
+	sv := reflect.ValueOf(v).Elem()
+	if tag := readByte(d.r); tag != tagObject {
+		panic(fmt.Sprintf("object expected, have %s", TagName(tag)))
+	}
+	d.addRef(incomplete{}) // the struct, which can't be referred to
+	count := d.readPropertyCount()
+	d.addElements(count)
 	for i := 0; i < count; i++ {
-		name := readAtom(r, atoms)
-		value := readValue(r, atoms)
-		setField(v, name, value)
+		name, value := d.readProperty()
+		if d.setField(sv, name, value) && d.present != nil {
+			d.present[name] = true
+		}
 	}
+	o.value(v)
 	return nil
 }
 
+// DecodeHooks are callbacks for observing a Decoder, e.g., for metrics
+// or tracing. Nil callbacks are skipped.
+type DecodeHooks struct {
+	// OnStart is called when Decode or DecodeObject starts.
+	OnStart func()
+	// OnValue is called after the top-level value is decoded, with the
+	// value (the struct pointer for DecodeObject) and the number of bytes
+	// it took, not counting the header.
+	OnValue func(v any, n int64)
+	// OnEnd is called when Decode or DecodeObject returns, with the total
+	// number of bytes read, the time it took and the error, if any.
+	OnEnd func(n int64, elapsed time.Duration, err error)
+}
+
+// observer counts the bytes read by a call to Decode or DecodeObject
+// and calls the hooks.
+type observer struct {
+	d      *Decoder
+	cr     *countingReader
+	start  time.Time
+	offset int64 // end of header
+}
+
+func (d *Decoder) observe() observer {
+	cr := &countingReader{r: d.r}
+	d.r = cr
+	d.bytesRead = 0
+	d.warnings = nil
+	d.elements = 0
+	d.buffers = 0
+	d.allowed = nil
+	d.interned = nil
+	d.internIDs = nil
+	o := observer{d: d, cr: cr}
+	if h := d.Hooks; h.OnStart != nil || h.OnEnd != nil {
+		o.start = time.Now()
+		if h.OnStart != nil {
+			h.OnStart()
+		}
+	}
+	return o
+}
+
+func (o *observer) header() {
+	o.offset = o.cr.n
+}
+
+func (o *observer) value(v any) {
+	if o.d.Hooks.OnValue != nil {
+		o.d.Hooks.OnValue(v, o.cr.n-o.offset)
+	}
+}
+
+func (o *observer) end(err error) {
+	o.d.r = o.cr.r
+	o.d.bytesRead = o.cr.n
+	if o.d.Hooks.OnEnd != nil {
+		o.d.Hooks.OnEnd(o.cr.n, time.Since(o.start), err)
+	}
+}
+
+// BytesRead returns the number of bytes that the last call to Decode or
+// DecodeObject consumed, including the header, also when it failed.
+func (d *Decoder) BytesRead() int64 {
+	return d.bytesRead
+}
+
+// lastOffset returns the offset in the input of the last byte read, or -1
+// if d isn't counting.
+func (d *Decoder) lastOffset() int64 {
+	if cr, ok := d.r.(*countingReader); ok {
+		return cr.n - 1
+	}
+	return -1
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+// WriteObject writes the exported fields of a struct, or of the struct a
+// pointer points to, as an object. Property names are the field names, or
+// the names from `js:"name"` tags; fields tagged `js:"-"` are skipped.
+// ReadObject reads it back.
+func WriteObject(w io.Writer, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("serde.WriteObject: struct expected, have %T", v)
+	}
+	return NewEncoder(w).Encode(rv.Interface())
+}
+
 // The wire format is somewhat inefficient in that object keys ("atoms")
 // go at the front, so you have to buffer the output until you're sure
-// you've seen all objects.
+// you've seen all objects. Values without objects, like numeric arrays,
+// are streamed in chunks of a few kilobytes instead, which means that
+// part of the blob may have been written to w when WriteValue fails,
+// e.g., because a BufferFrom reader ends early.
 func WriteValue(w io.Writer, v any) (err error) {
-	defer func() {
-		if x := recover(); x != nil {
-			switch v := x.(type) {
-			case error:
-				err = v
-			default:
-				err = fmt.Errorf("serde.ReadObject: %v", v)
+	return NewEncoder(w).Encode(v)
+}
+
+// WriteValueCounting returns the number of bytes WriteValue writes for v,
+// without keeping them.
+func WriteValueCounting(v any) (int, error) {
+	cw := countingWriter{}
+	err := WriteValue(&cw, v)
+	return int(cw.n), err
+}
+
+// WriteValueOrdered writes m with its properties in the order of keys,
+// e.g., to match a schema. Every key must be present in m and, unless
+// Encoder.UnlistedKeys is set, every key of m must be in keys.
+func WriteValueOrdered(w io.Writer, m map[string]any, keys []string) error {
+	return NewEncoder(w).EncodeOrdered(m, keys)
+}
+
+// EncodeOrdered is the Encoder version of WriteValueOrdered.
+func (e *Encoder) EncodeOrdered(m map[string]any, keys []string) error {
+	o := &Object{Props: make([]Property, 0, len(m))}
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		v, ok := m[k]
+		if !ok {
+			return fmt.Errorf("serde.WriteValueOrdered: missing key %q", k)
+		}
+		if seen[k] {
+			return fmt.Errorf("serde.WriteValueOrdered: duplicate key %q", k)
+		}
+		seen[k] = true
+		o.Props = append(o.Props, Property{k, v})
+	}
+	if len(seen) < len(m) {
+		rest := make([]string, 0, len(m)-len(seen))
+		for k := range m {
+			if !seen[k] {
+				rest = append(rest, k)
 			}
 		}
-	}()
-	atoms := []string{} // TODO
-	write(w, []byte{bcVersion})
-	writeUvarint(w, len(atoms))
+		sort.Strings(rest)
+		if !e.UnlistedKeys {
+			return fmt.Errorf("serde.WriteValueOrdered: unlisted key %q", rest[0])
+		}
+		for _, k := range rest {
+			o.Props = append(o.Props, Property{k, m[k]})
+		}
+	}
+	return e.Encode(o)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	cw.n += int64(len(b))
+	return len(b), nil
+}
+
+// ErrUnsupportedType matches errors for values the encoder can't serialize.
+var ErrUnsupportedType = errors.New("unsupported type")
+
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported type %v (%v)", e.Type, e.Type.Kind())
+}
+
+func (e *UnsupportedTypeError) Unwrap() error {
+	return ErrUnsupportedType
+}
+
+// TypedArrayBufferError is returned for typed arrays that are followed by
+// something other than their ArrayBuffer.
+type TypedArrayBufferError struct {
+	Tag    byte  // see TagName
+	Offset int64 // of Tag in the input, -1 if unknown
+}
+
+func (e *TypedArrayBufferError) Error() string {
+	return fmt.Sprintf("typed array not followed by arraybuffer but by %s at offset %d", TagName(e.Tag), e.Offset)
+}
+
+// TruncatedTypedArrayError is returned when the input ends inside the
+// inline arraybuffer of a typed array.
+type TruncatedTypedArrayError struct {
+	Kind   byte  // see TypedArrayKindName
+	Length int   // declared number of elements
+	Offset int64 // of the arraybuffer contents in the input, -1 if unknown
+	Read   int   // bytes of the arraybuffer read before the input ended
+	Size   int   // bytes in the arraybuffer
+	Err    error // usually io.ErrUnexpectedEOF
+}
+
+func (e *TruncatedTypedArrayError) Error() string {
+	return fmt.Sprintf("truncated %s of %d elements at offset %d: read %d of %d arraybuffer bytes: %v",
+		TypedArrayKindName(e.Kind), e.Length, e.Offset, e.Read, e.Size, e.Err)
+}
+
+func (e *TruncatedTypedArrayError) Unwrap() error {
+	return e.Err
+}
+
+// VersionMismatchError is returned for blobs written by a QuickJS version
+// with an incompatible serialization format.
+type VersionMismatchError struct {
+	Have, Want byte
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("version mismatch (have %d, want %d)", e.Have, e.Want)
+}
+
+// Encoder writes values to an output stream. The zero value of each
+// option field selects the default behavior of WriteValue.
+type Encoder struct {
+	// OnUnsupported is called for values of a type the encoder doesn't
+	// know how to serialize and returns the value to write in its place.
+	// The replacement must be of a supported type; it is not passed to
+	// OnUnsupported again.
+	OnUnsupported func(v any) (any, error)
+
+	// StringAtoms writes every property name to the atom table, also
+	// canonical array indices like "42" that are otherwise written as
+	// tagged ints. Names that aren't canonical, like "007", always go to
+	// the atom table, so they are preserved either way.
+	StringAtoms bool
+
+	// NumbersAsFloat writes all numbers as float64, also integers that
+	// fit in an int32. It doesn't affect typed arrays.
+	NumbersAsFloat bool
+
+	// UnlistedKeys makes EncodeOrdered write the properties that aren't
+	// in its list of keys after the listed ones, in sorted order, instead
+	// of failing.
+	UnlistedKeys bool
+
+	// MaxOutputBytes makes Encode fail as soon as the blob would be
+	// larger than this many bytes, before anything is written to the
+	// output. Zero means no limit.
+	MaxOutputBytes int
+
+	// ZeroCopy writes the memory of typed arrays to the output as-is on
+	// little-endian hosts, instead of converting element by element.
+	// The writer gets a slice that aliases the typed array, also when
+	// the typed array is part of a larger one.
+	ZeroCopy bool
+
+	// FrequentAtomsFirst gives the property names that occur most often
+	// the lowest indices in the atom table, which makes references to
+	// them smaller: indices up to 63 take one byte, up to 8191 two bytes.
+	// It makes a difference for values with more than 63 distinct
+	// property names. The value is traversed twice, which means that
+	// OnUnsupported is called twice for each unsupported value.
+	FrequentAtomsFirst bool
+
+	w          io.Writer
+	atoms      []string
+	index      map[string]int // atom -> 1-based index into atoms
+	visiting   map[visit]bool
+	converting bool
+	narrow     bool                                   // write integral float64s as int32
+	atomBytes  int                                    // size of the atom strings, for MaxOutputBytes
+	fields     map[reflect.Type][]reflect.StructField // see writeStruct
+	uses       map[string]int                         // see FrequentAtomsFirst
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes v like WriteValue does, also when it comes to writing
+// part of the blob on failure.
+func (e *Encoder) Encode(v any) (err error) {
+	defer recoverError(&err, "serde.WriteValue")
+	e.atoms = nil
+	e.index = map[string]int{}
+	e.visiting = map[visit]bool{}
+	e.atomBytes = 0
+	if e.MaxOutputBytes == 0 && noAtoms(v, 8) {
+		// the atom table is empty, no need to hold on to the body, but
+		// collect the many small writes into larger ones
+		var w io.Writer = e.w
+		var bw *bufio.Writer
+		switch e.w.(type) {
+		case *bytes.Buffer, *bufio.Writer:
+		default:
+			bw = bufio.NewWriter(e.w)
+			w = bw
+		}
+		write(w, []byte{bcVersion, 0})
+		e.writeValue(w, v)
+		if bw != nil {
+			panicIf(bw.Flush())
+		}
+		return nil
+	}
+	if e.FrequentAtomsFirst {
+		e.uses = map[string]int{}
+		e.writeValue(discard{}, v)
+		e.sortAtoms()
+		e.uses = nil
+	}
+	body := encodeBuffer{}
+	if e.MaxOutputBytes > 0 {
+		e.writeValue(&budgetWriter{e, &body}, v)
+	} else {
+		e.writeValue(&body, v)
+	}
+	write(e.w, []byte{bcVersion})
+	writeUvarint(e.w, len(e.atoms))
+	for _, atom := range e.atoms {
+		writeString(e.w, atom)
+	}
+	body.writeTo(e.w)
+	return nil
+}
+
+// noAtoms reports whether v is a value that can be written without adding
+// atoms, looking at most depth levels deep into arrays. It only knows
+// about the common types; false means that v may have atoms.
+func noAtoms(v any, depth int) bool {
+	switch t := v.(type) {
+	case nil, UndefinedValue, bool, int, int32, float32, float64, string, *big.Int,
+		BoxedBool, BoxedNumber, BoxedString, time.Time, InvalidDate,
+		ArrayBuffer, BufferSource, SharedArrayBuffer, Uint8ClampedArray,
+		[]byte, []int8, []int16, []uint16, []int32, []uint32, []int64, []uint64, []float32, []float64:
+		return true
+	case []any:
+		if depth == 0 {
+			return false
+		}
+		for _, e := range t {
+			if !noAtoms(e, depth-1) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sortAtoms orders e.atoms by the number of uses, most used first, and
+// by first use for atoms that are used equally often.
+func (e *Encoder) sortAtoms() {
+	sort.SliceStable(e.atoms, func(i, j int) bool {
+		return e.uses[e.atoms[i]] > e.uses[e.atoms[j]]
+	})
+	for i, atom := range e.atoms {
+		e.index[atom] = i + 1
+	}
+}
+
+// discard is io.Discard for the counting pass of FrequentAtomsFirst. It
+// drops BufferFrom readers without reading them.
+type discard struct{}
+
+func (discard) Write(b []byte) (int, error) { return len(b), nil }
+
+func (discard) splice(r io.Reader, n int) {}
+
+// encodeBuffer holds the body while Encode collects the atoms. The
+// contents of BufferFrom readers are not buffered, they are copied to the
+// output when the body is written out.
+type encodeBuffer struct {
+	bytes.Buffer // since the last reader
+	segs         []segment
+	n            int // length of segs
+}
+
+type segment struct {
+	data []byte
+	r    io.Reader // followed by n bytes from r
+	n    int
+}
+
+func (b *encodeBuffer) Len() int {
+	return b.n + b.Buffer.Len()
+}
+
+func (b *encodeBuffer) splice(r io.Reader, n int) {
+	data := b.Buffer.Bytes()
+	b.segs = append(b.segs, segment{data, r, n})
+	b.n += len(data) + n
+	b.Buffer = bytes.Buffer{}
+}
+
+func (b *encodeBuffer) writeTo(w io.Writer) {
+	for _, s := range b.segs {
+		write(w, s.data)
+		if m, err := io.CopyN(w, s.r, int64(s.n)); err == io.EOF {
+			panic(fmt.Sprintf("BufferFrom reader ended after %d of %d bytes", m, s.n))
+		} else if err != nil {
+			panic(err)
+		}
+	}
+	write(w, b.Buffer.Bytes())
+}
+
+// splicer is implemented by the writers that Encode passes to writeValue.
+type splicer interface {
+	splice(r io.Reader, n int)
+}
+
+func (e *Encoder) writeValue(w io.Writer, v any) {
 	switch t := v.(type) {
 	case nil:
 		write(w, []byte{tagNull})
@@ -138,47 +1186,564 @@ func WriteValue(w io.Writer, v any) (err error) {
 			b = tagTrue
 		}
 		write(w, []byte{b})
+	case BufferSource:
+		write(w, []byte{tagArrayBuffer})
+		writeUvarint(w, t.n)
+		if s, ok := w.(splicer); ok {
+			s.splice(t.r, t.n)
+		} else if m, err := io.CopyN(w, t.r, int64(t.n)); err == io.EOF {
+			panic(fmt.Sprintf("BufferFrom reader ended after %d of %d bytes", m, t.n))
+		} else {
+			panicIf(err)
+		}
 	case ArrayBuffer:
 		write(w, []byte{tagArrayBuffer})
 		writeUvarint(w, len(t.Bytes))
 		write(w, t.Bytes)
+	case time.Time:
+		write(w, []byte{tagDate})
+		writeFloat64(w, millisFromTime(t))
+	case InvalidDate:
+		write(w, []byte{tagDate})
+		writeFloat64(w, math.NaN())
+	case SharedArrayBuffer:
+		writeSharedArrayBuffer(w, t)
+	case RegExp:
+		panic(&UnsupportedTypeError{reflect.TypeOf(t)})
 	case Uint8ClampedArray:
-		writeTypedArray(w, len(t.Bytes), t.Bytes, uint8ClampedArray)
+		e.writeTypedArray(w, len(t.Bytes), t.Bytes, uint8ClampedArray)
 	case []byte:
-		writeTypedArray(w, len(t), t, uint8Array)
+		e.writeTypedArray(w, len(t), t, uint8Array)
 	case []int8:
-		writeTypedArray(w, len(t), t, int8Array)
+		e.writeTypedArray(w, len(t), t, int8Array)
 	case []int16:
-		writeTypedArray(w, len(t), t, int16Array)
+		e.writeTypedArray(w, len(t), t, int16Array)
 	case []uint16:
-		writeTypedArray(w, len(t), t, uint16Array)
+		e.writeTypedArray(w, len(t), t, uint16Array)
 	case []int32:
-		writeTypedArray(w, len(t), t, int32Array)
+		e.writeTypedArray(w, len(t), t, int32Array)
 	case []uint32:
-		writeTypedArray(w, len(t), t, uint32Array)
+		e.writeTypedArray(w, len(t), t, uint32Array)
 	case []int64:
-		writeTypedArray(w, len(t), t, bigInt64Array)
+		e.writeTypedArray(w, len(t), t, bigInt64Array)
 	case []uint64:
-		writeTypedArray(w, len(t), t, bigUint64Array)
+		e.writeTypedArray(w, len(t), t, bigUint64Array)
 	case []float32:
-		writeTypedArray(w, len(t), t, float32Array)
+		e.writeTypedArray(w, len(t), t, float32Array)
 	case []float64:
-		writeTypedArray(w, len(t), t, float64Array)
+		e.writeTypedArray(w, len(t), t, float64Array)
+	case int32:
+		e.writeInt(w, t)
+	case float64:
+		if n, ok := asInt32(t); e.narrow && ok {
+			e.writeInt(w, n)
+		} else {
+			writeFloat64(w, t)
+		}
+	case json.RawMessage:
+		// transcode, with integral numbers narrowed like JSON.parse does
+		var v any
+		if err := json.Unmarshal(t, &v); err != nil {
+			panic(fmt.Sprintf("invalid JSON: %v", err))
+		}
+		defer func(narrow bool) { e.narrow = narrow }(e.narrow)
+		e.narrow = true
+		e.writeValue(w, v)
+	case float32:
+		// JS has no float32 scalar; widening is exact
+		e.writeValue(w, float64(t))
+	case Number:
+		// "-0" parses as an integer but isn't one
+		if n, err := strconv.ParseInt(string(t), 10, 32); err == nil && t != "-0" {
+			e.writeInt(w, int32(n))
+		} else {
+			f, err := t.Float64()
+			panicIf(err)
+			writeFloat64(w, f)
+		}
+	case string:
+		write(w, []byte{tagString})
+		writeString(w, t)
+	case *big.Int:
+		writeBigInt(w, t)
+	case BoxedBool:
+		write(w, []byte{tagObjectValue})
+		e.writeValue(w, t.Value)
+	case BoxedNumber:
+		write(w, []byte{tagObjectValue})
+		if n, ok := asInt32(t.Value); ok {
+			e.writeInt(w, n)
+		} else {
+			writeFloat64(w, t.Value)
+		}
+	case BoxedString:
+		write(w, []byte{tagObjectValue})
+		e.writeValue(w, t.Value)
+	case RawValue:
+		e.writeRaw(w, t)
+	case Runes:
+		write(w, []byte{tagString})
+		writeString(w, string(t))
+	case StringBytes:
+		write(w, []byte{tagString})
+		writeString(w, []byte(t))
+	case []any:
+		if len(t) > 0 {
+			defer e.enter(t, unsafe.Pointer(&t[0]))()
+		}
+		write(w, []byte{tagArray})
+		writeUvarint(w, len(t))
+		for _, v := range t {
+			e.writeValue(w, v)
+		}
+	case map[string]any:
+		defer e.enter(t, reflect.ValueOf(t).UnsafePointer())()
+		e.writeObject(w, t)
+	case *Object:
+		defer e.enter(t, unsafe.Pointer(t))()
+		write(w, []byte{tagObject})
+		writeUvarint(w, len(t.Props))
+		for _, p := range t.Props {
+			e.writeAtom(w, p.Key)
+			e.writeValue(w, p.Value)
+		}
+	case error:
+		// serialize as an Error-like object; the JS side sees .message
+		e.writeObject(w, map[string]any{"message": t.Error()})
 	default:
-		panic(fmt.Sprintf("unsupported type %t", t))
+		if e.writeReflect(w, v) {
+			return
+		}
+		if e.OnUnsupported == nil || e.converting {
+			panic(&UnsupportedTypeError{reflect.TypeOf(v)})
+		}
+		v, err := e.OnUnsupported(v)
+		panicIf(err)
+		e.converting = true
+		defer func() { e.converting = false }()
+		e.writeValue(w, v)
+	}
+}
+
+// writeReflect handles values whose type isn't matched by writeValue's
+// type switch, such as named slice types, structs and maps with values
+// other than any. It reports whether it wrote v. Elements go through
+// writeValue again, so nested values of any supported type work, and
+// their property names are added to the atom table as they're written;
+// there is no separate pass that could miss them.
+func (e *Encoder) writeReflect(w io.Writer, v any) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		e.writeValue(w, rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInteger(w, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := rv.Uint(); n > math.MaxInt32 {
+			writeFloat64(w, float64(n))
+		} else {
+			e.writeInt(w, int32(n))
+		}
+	case reflect.Float32, reflect.Float64:
+		e.writeValue(w, rv.Float())
+	case reflect.Complex64, reflect.Complex128:
+		// JS has no complex numbers, see AsComplex for the way back
+		c := rv.Complex()
+		write(w, []byte{tagObject})
+		writeUvarint(w, 2)
+		e.writeAtom(w, "re")
+		e.writeValue(w, real(c))
+		e.writeAtom(w, "im")
+		e.writeValue(w, imag(c))
+	case reflect.String:
+		e.writeValue(w, rv.String())
+	case reflect.Slice:
+		if kind, ok := typedArrayKinds[rv.Type().Elem().Kind()]; ok {
+			e.writeTypedArray(w, rv.Len(), v, kind)
+			break
+		}
+		if rv.Len() > 0 {
+			defer e.enter(v, rv.Index(0).Addr().UnsafePointer())()
+		}
+		write(w, []byte{tagArray})
+		writeUvarint(w, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			e.writeValue(w, rv.Index(i).Interface())
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		defer e.enter(v, rv.UnsafePointer())()
+		e.writeMap(w, rv)
+	case reflect.Struct:
+		e.writeStruct(w, rv)
+	case reflect.Pointer:
+		// written as the value pointed to, like encoding/json
+		if rv.IsNil() {
+			write(w, []byte{tagNull})
+			break
+		}
+		defer e.enter(v, rv.UnsafePointer())()
+		e.writeValue(w, rv.Elem().Interface())
+	default:
+		return false
+	}
+	return true
+}
+
+// writeInteger writes n as an int32 if it fits, else as a float64, which
+// loses precision beyond 2^53 like JS numbers do.
+func (e *Encoder) writeInteger(w io.Writer, n int64) {
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		writeFloat64(w, float64(n))
+	} else {
+		e.writeInt(w, int32(n))
 	}
-	return nil
 }
 
-func writeTypedArray(w io.Writer, n int, v any, tag byte) {
+// writeInt writes an int32, or a float64 with NumbersAsFloat.
+func (e *Encoder) writeInt(w io.Writer, n int32) {
+	if e.NumbersAsFloat {
+		writeFloat64(w, float64(n))
+	} else {
+		writeInt32(w, n)
+	}
+}
+
+// writeStruct writes the exported fields of a struct as an object, with
+// the property names that ReadObject maps back to the fields. The fields
+// are looked up once per type, not for every element of a slice of
+// structs.
+func (e *Encoder) writeStruct(w io.Writer, rv reflect.Value) {
+	t := rv.Type()
+	fields, ok := e.fields[t]
+	if !ok {
+		if e.fields == nil {
+			e.fields = map[reflect.Type][]reflect.StructField{}
+		}
+		fields = structFields(t)
+		e.fields[t] = fields
+	}
+	write(w, []byte{tagObject})
+	writeUvarint(w, len(fields))
+	for _, f := range fields {
+		e.writeAtom(w, fieldName(f))
+		e.writeValue(w, rv.FieldByIndex(f.Index).Interface())
+	}
+}
+
+// structFields returns the fields that writeStruct writes, in declaration
+// order. Fields of embedded structs are included as if they were fields
+// of the outer struct.
+func structFields(t reflect.Type) []reflect.StructField {
+	fields := []reflect.StructField{}
+	seen := map[string]bool{}
+	for _, f := range reflect.VisibleFields(t) {
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue // its fields follow
+		}
+		if name := fieldName(f); f.IsExported() && name != "" && !seen[name] && !viaPointer(t, f.Index) {
+			seen[name] = true
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// viaPointer reports whether a field is promoted through an embedded
+// pointer, which FieldByIndex can't follow if the pointer is nil.
+func viaPointer(t reflect.Type, index []int) bool {
+	for _, i := range index[:len(index)-1] {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Pointer {
+			return true
+		}
+		t = f.Type
+	}
+	return false
+}
+
+var typedArrayKinds = map[reflect.Kind]byte{
+	reflect.Int8:    int8Array,
+	reflect.Uint8:   uint8Array,
+	reflect.Int16:   int16Array,
+	reflect.Uint16:  uint16Array,
+	reflect.Int32:   int32Array,
+	reflect.Uint32:  uint32Array,
+	reflect.Int64:   bigInt64Array,
+	reflect.Uint64:  bigUint64Array,
+	reflect.Float32: float32Array,
+	reflect.Float64: float64Array,
+}
+
+// Keys are written in sorted order so the output is deterministic.
+// enter detects cycles. The object graph is written as a tree, shared
+// objects are written once for each reference.
+// visit identifies a value that is being written. The type is part of
+// the key because a struct and its first field share an address.
+type visit struct {
+	t reflect.Type
+	p unsafe.Pointer
+}
+
+func (e *Encoder) enter(v any, p unsafe.Pointer) (leave func()) {
+	k := visit{reflect.TypeOf(v), p}
+	if e.visiting[k] {
+		panic("cyclic value")
+	}
+	e.visiting[k] = true
+	return func() { delete(e.visiting, k) }
+}
+
+// writeMap is writeObject for maps with other value or key types.
+func (e *Encoder) writeMap(w io.Writer, rv reflect.Value) {
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	write(w, []byte{tagObject})
+	writeUvarint(w, len(keys))
+	for _, k := range keys {
+		e.writeAtom(w, k.String())
+		e.writeValue(w, rv.MapIndex(k).Interface())
+	}
+}
+
+func (e *Encoder) writeObject(w io.Writer, m map[string]any) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	write(w, []byte{tagObject})
+	writeUvarint(w, len(keys))
+	for _, k := range keys {
+		e.writeAtom(w, k)
+		e.writeValue(w, m[k])
+	}
+}
+
+// budgetWriter enforces Encoder.MaxOutputBytes while the body is
+// buffered. It counts the header as it is so far, so it fails as soon as
+// the atom table plus the body so far are too big.
+type budgetWriter struct {
+	e    *Encoder
+	body *encodeBuffer
+}
+
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	bw.check(len(p))
+	return bw.body.Write(p)
+}
+
+func (bw *budgetWriter) splice(r io.Reader, n int) {
+	bw.check(n)
+	bw.body.splice(r, n)
+}
+
+func (bw *budgetWriter) check(more int) {
+	e := bw.e
+	var b [binary.MaxVarintLen64]byte
+	n := 1 + binary.PutUvarint(b[:], uint64(len(e.atoms))) + e.atomBytes + bw.body.Len() + more
+	if n > e.MaxOutputBytes {
+		panic(fmt.Sprintf("output exceeds %d bytes", e.MaxOutputBytes))
+	}
+}
+
+// Mirrors readAtom: canonical array indices are written as tagged ints,
+// everything else goes into the atom table.
+func (e *Encoder) writeAtom(w io.Writer, s string) {
+	if n, ok := atomInt(s); ok && !e.StringAtoms {
+		writeUvarint(w, n<<1|1)
+		return
+	}
+	if e.uses != nil {
+		e.uses[s]++
+	}
+	idx, ok := e.index[s]
+	if !ok {
+		e.atoms = append(e.atoms, s)
+		idx = len(e.atoms)
+		e.index[s] = idx
+		if e.MaxOutputBytes > 0 {
+			buf := bytes.Buffer{}
+			writeString(&buf, s)
+			e.atomBytes += buf.Len()
+		}
+	}
+	writeUvarint(w, idx<<1)
+}
+
+// atomInt reports whether s is the canonical decimal representation of
+// an integer in the range QuickJS stores as a tagged int atom.
+func atomInt(s string) (int, bool) {
+	if s == "" || len(s) > 10 || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		n = 10*n + int(s[i]-'0')
+	}
+	if n > math.MaxInt32 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (e *Encoder) writeTypedArray(w io.Writer, n int, v any, tag byte) {
 	write(w, []byte{tagTypedArray, tag})
 	writeUvarint(w, n)
 	writeUvarint(w, 0)
 	write(w, []byte{tagArrayBuffer})
-	writeUvarint(w, n)
+	size := n * typedArrayElementSize(tag)
+	writeUvarint(w, size)
+	if e.ZeroCopy && hostLittleEndian && size > 0 {
+		p := reflect.ValueOf(v).UnsafePointer()
+		write(w, unsafe.Slice((*byte)(p), size))
+		return
+	}
 	panicIf(binary.Write(w, binary.LittleEndian, v))
 }
 
+// SharedArrayBuffers are written as their length, maximum length and
+// address. The maximum length of a buffer that isn't growable is 2^32-1.
+func writeSharedArrayBuffer(w io.Writer, v SharedArrayBuffer) {
+	write(w, []byte{tagSharedArrayBuffer})
+	writeUvarint(w, v.ByteLength)
+	if v.MaxByteLength < 0 {
+		writeUvarint(w, math.MaxUint32)
+	} else {
+		writeUvarint(w, v.MaxByteLength)
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v.Addr)
+	write(w, b[:])
+}
+
+func readSharedArrayBuffer(r io.Reader, order binary.ByteOrder) SharedArrayBuffer {
+	v := SharedArrayBuffer{ByteLength: readUint32(r), MaxByteLength: readUint32(r)}
+	if v.MaxByteLength == math.MaxUint32 {
+		v.MaxByteLength = -1
+	} else if v.MaxByteLength < v.ByteLength {
+		panic(fmt.Sprintf("sharedarraybuffer of %d bytes has smaller maximum length %d", v.ByteLength, v.MaxByteLength))
+	}
+	v.Addr = order.Uint64(readBytes(r, 8))
+	return v
+}
+
+// BigInts are written as a byte count followed by the value in two's
+// complement, little-endian, in as few bytes as possible. Zero has no
+// bytes.
+func writeBigInt(w io.Writer, v *big.Int) {
+	n := 0
+	m := new(big.Int)
+	if v.Sign() < 0 {
+		n = m.Not(v).BitLen()/8 + 1 // -v-1 fits in n*8-1 bits
+		m.Lsh(big.NewInt(1), uint(8*n)).Add(m, v)
+	} else if v.Sign() > 0 {
+		n = v.BitLen()/8 + 1
+		m.Set(v)
+	}
+	b := m.FillBytes(make([]byte, n))
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	write(w, []byte{tagBigInt})
+	writeUvarint(w, n)
+	write(w, b)
+}
+
+// readBigInt accepts encodings that are longer than necessary, like the
+// ones that pad to a whole number of limbs, but that's not what QuickJS
+// writes: small and large BigInts share the minimal form.
+func readBigInt(r io.Reader) *big.Int {
+	return bigIntFromBytes(readBytes(r, readUint32(r)))
+}
+
+// bigIntFromBytes decodes two's complement little-endian bytes. b is
+// clobbered.
+func bigIntFromBytes(b []byte) *big.Int {
+	n := len(b)
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	v := new(big.Int).SetBytes(b)
+	if n > 0 && b[0]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(8*n)))
+	}
+	return v
+}
+
+// smallBigInt is bigIntFromBytes for values of at most 8 bytes.
+func smallBigInt(b []byte) (int64, bool) {
+	if len(b) > 8 {
+		return 0, false
+	}
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*len(b) // sign-extend
+	return int64(u<<shift) >> shift, true
+}
+
+// asInt32 reports whether f is integral and fits in an int32. Negative
+// zero does not.
+func asInt32(f float64) (int32, bool) {
+	if f < math.MinInt32 || f > math.MaxInt32 || f != math.Trunc(f) || (f == 0 && math.Signbit(f)) {
+		return 0, false
+	}
+	return int32(f), true
+}
+
+// Zigzag encoding, like QuickJS's bc_put_sleb128(). binary.PutVarint uses
+// the same encoding and readValue inverts it with binary.ReadVarint.
+func writeInt32(w io.Writer, v int32) {
+	var b [binary.MaxVarintLen32 + 1]byte
+	b[0] = tagInt32
+	n := binary.PutVarint(b[1:], int64(v))
+	write(w, b[:1+n])
+}
+
+// timeFromMillis converts a JS time value, milliseconds since the epoch,
+// to a time.Time. It splits the value in seconds and nanoseconds instead
+// of multiplying it by 1e6 because that overflows an int64 for dates
+// before 1678 or after 2262. millisFromTime reconstructs the exact value
+// for time values with nanosecond precision, which includes the whole
+// milliseconds JS dates hold, and for any fractional value more than a
+// few months from the epoch, where a float64 has less than nanosecond
+// precision.
+func timeFromMillis(ms float64) any {
+	if math.IsNaN(ms) || math.IsInf(ms, 0) {
+		return InvalidDate{}
+	}
+	sec := math.Floor(ms / 1000)
+	rem := ms - sec*1000 // exact
+	if rem < 0 {
+		sec, rem = sec-1, rem+1000
+	} else if rem >= 1000 {
+		sec, rem = sec+1, rem-1000
+	}
+	nsec := math.Round(rem * 1e6)
+	if nsec >= 1e9 {
+		sec, nsec = sec+1, nsec-1e9
+	}
+	return time.Unix(int64(sec), int64(nsec)).UTC()
+}
+
+func millisFromTime(t time.Time) float64 {
+	return float64(t.Unix())*1000 + float64(t.Nanosecond())/1e6
+}
+
+func writeFloat64(w io.Writer, v float64) {
+	var b [9]byte
+	b[0] = tagFloat64
+	binary.LittleEndian.PutUint64(b[1:], math.Float64bits(v))
+	write(w, b[:])
+}
+
 func write(w io.Writer, b []byte) {
 	if _, err := w.Write(b); err != nil {
 		panic(err)
@@ -186,38 +1751,100 @@ func write(w io.Writer, b []byte) {
 }
 
 func writeUvarint(w io.Writer, v int) {
-	var b [8]byte
+	var b [binary.MaxVarintLen64]byte
 	n := binary.PutUvarint(b[:], uint64(v))
 	write(w, b[:n])
 }
 
-func readHeader(r io.Reader) []string {
-	if version := readByte(r); version != bcVersion {
-		panic(fmt.Sprintf("version mismatch (have %d, want %d)", version, bcVersion))
+// Narrow strings are only used for ASCII because the decoder doesn't
+// interpret them as latin1 by default; everything else is UTF-16.
+func writeString[T string | []byte](w io.Writer, s T) {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			h := utf16.Encode([]rune(string(s)))
+			writeUvarint(w, len(h)<<1|1)
+			panicIf(binary.Write(w, binary.LittleEndian, h))
+			return
+		}
+	}
+	writeUvarint(w, len(s)<<1)
+	write(w, []byte(s))
+}
+
+func (d *Decoder) readHeader() {
+	if version := readByte(d.r); version != bcVersion {
+		panic(&VersionMismatchError{version, bcVersion})
+	}
+	count := readUint32(d.r)
+	d.key = ""
+	for i := range d.refs {
+		d.refs[i] = nil
+	}
+	d.refs = d.refs[:0]
+	if d.shared {
+		d.atoms = nil
+		d.shared = false
 	}
-	count := readUint32(r)
-	atoms := make([]string, count)
+	// don't trust count for the allocation, like for arrays
+	d.atoms = d.atoms[:0]
 	for i := 0; i < count; i++ {
-		atoms[i] = readString(r)
+		d.atoms = append(d.atoms, d.readString())
 	}
-	return atoms
 }
 
-func readAtom(r io.Reader, atoms []string) string {
-	idx := readUint32(r)
+func (d *Decoder) readAtom() string {
+	idx := readUint32(d.r)
 	isTaggedInt := (idx & 1) == 1
 	idx = idx >> 1
 	if isTaggedInt {
 		return fmt.Sprintf("%d", idx)
 	}
-	if idx > 0 && idx <= len(atoms) {
-		return atoms[idx-1]
+	if idx > 0 && idx <= len(d.atoms) {
+		return d.atoms[idx-1]
+	}
+	if d.LenientAtoms {
+		return fmt.Sprintf("<atom %d>", idx)
 	}
 	panic("atom out of range")
 }
 
-func readValue(r io.Reader, atoms []string) any {
-	switch tag := readByte(r); tag {
+func (d *Decoder) readValue() any {
+	return d.readBody(d.readTag())
+}
+
+// readTag reads the tag of a value and checks it against AllowedTags.
+func (d *Decoder) readTag() byte {
+	tag := readByte(d.r)
+	if d.AllowedTags == nil {
+		return tag
+	}
+	if d.allowed == nil {
+		d.allowed = allowedTags(d.AllowedTags)
+	}
+	if !d.allowed[tag] {
+		panic(fmt.Sprintf("%s not allowed at offset %d", TagName(tag), d.lastOffset()))
+	}
+	return tag
+}
+
+func allowedTags(names []string) *[256]bool {
+	allowed := [256]bool{}
+	for _, name := range names {
+		tag := byte(tagNull)
+		for ; tag <= tagObjectReference && TagName(tag) != name; tag++ {
+		}
+		if tag > tagObjectReference {
+			panic(fmt.Sprintf("unknown tag name %q", name))
+		}
+		allowed[tag] = true
+	}
+	return &allowed
+}
+
+// readBody reads the rest of a value after its tag.
+func (d *Decoder) readBody(tag byte) any {
+	r := d.r
+	switch tag {
 	case tagNull:
 		return nil
 	case tagUndefined:
@@ -234,95 +1861,443 @@ func readValue(r io.Reader, atoms []string) any {
 		if v < math.MinInt32 || v > math.MaxInt32 {
 			panic(fmt.Sprintf("int32 out of range: %d", v))
 		}
+		if d.NumberHook != nil && !d.wrapped {
+			return d.number(tagInt32, int32(v))
+		}
+		if d.UseNumber {
+			return Number(strconv.FormatInt(v, 10))
+		}
 		return int32(v)
 	case tagFloat64:
+		// read as raw bits; NaN payloads are preserved, not canonicalized
 		var v float64
-		panicIf(binary.Read(r, binary.LittleEndian, &v))
+		panicIf(binary.Read(r, d.order(), &v))
+		if d.NumberHook != nil && !d.wrapped {
+			return d.number(tagFloat64, v)
+		}
+		if n, ok := asInt32(v); ok && d.NarrowFloats {
+			if d.UseNumber {
+				return Number(strconv.Itoa(int(n)))
+			}
+			return n
+		}
+		if d.UseNumber {
+			return Number(strconv.FormatFloat(v, 'g', -1, 64))
+		}
 		return v
 	case tagString:
-		return readString(r)
+		if d.StringsAsBytes && !d.wrapped {
+			return d.readStringBytes()
+		}
+		if d.Intern != nil {
+			return d.Intern(d.readString())
+		}
+		return d.readString()
+	case tagBigInt:
+		b := readBytes(r, readUint32(r))
+		if !d.BigIntAsInt64 {
+			return bigIntFromBytes(b)
+		}
+		if v, ok := smallBigInt(b); ok {
+			return v // fast path, doesn't allocate
+		}
+		if v := bigIntFromBytes(b); !v.IsInt64() {
+			return v
+		} else {
+			return v.Int64()
+		}
 	case tagObject:
-		n := readUint32(r)
-		m := make(map[string]any, n)
+		// the only object form: bytecode version 12 has no compact
+		// encoding for small objects and doesn't serialize shapes;
+		// blobs from other versions fail in readHeader. QuickJS only
+		// writes enumerable properties with string keys, symbol keys
+		// never make it into a blob
+		n := d.readPropertyCount()
+		d.addElements(n)
+		if d.OrderedObjects {
+			o := &Object{Props: make([]Property, 0, preallocLimit(n))}
+			d.addRef(o)
+			for i := 0; i < n; i++ {
+				key, v := d.readProperty()
+				o.Props = append(o.Props, Property{key, v})
+			}
+			return o
+		}
+		m := make(map[string]any, preallocLimit(n))
+		idx := d.addRef(m)
 		for i := 0; i < n; i++ {
-			atom := readAtom(r, atoms)
-			m[atom] = readValue(r, atoms)
+			key, v := d.readProperty()
+			if _, ok := m[key]; ok && d.CollectWarnings {
+				d.warn("duplicate property %q", key)
+			}
+			m[key] = v
 		}
-		return m
+		return d.intern(idx, m)
 	case tagArray:
 		n := readUint32(r)
-		v := make([]any, n)
+		d.addElements(n)
+		idx := d.addRef(incomplete{})
+		if n <= maxPrealloc {
+			// allocate up front so that elements can refer to the array
+			v := make([]any, n)
+			d.refs[idx] = v
+			for i := range v {
+				v[i] = d.readElem()
+			}
+			return d.intern(idx, v)
+		}
+		// don't trust n for the initial allocation, it may be a lie.
+		// Elements that refer to the array get a placeholder instead
+		d.refs[idx] = pendingArray{}
+		v := make([]any, 0, maxPrealloc)
 		for i := 0; i < n; i++ {
-			v[i] = readValue(r, atoms)
+			v = append(v, d.readElem())
 		}
-		return v
+		if d.refs[idx].(pendingArray).referenced {
+			patchArray(v, arrayRef(idx), v, map[unsafe.Pointer]bool{})
+		}
+		d.refs[idx] = v
+		return d.intern(idx, v)
 	case tagArrayBuffer:
 		n := readUint32(r)
-		return readBytes(r, n)
-	case tagTypedArray:
-		tag := readByte(r)
-		n := readUint32(r)
-		// offset into arraybuffer (t time of serialization;
-		// *not* an offset into the arraybuffer following
-		// this typed array
-		_ = readUint32(r)
-		if tagArrayBuffer != readByte(r) {
-			panic("typed array not followed by arraybuffer")
-		}
-		if n != readUint32(r) {
-			panic("typed array not followed by arraybuffer of right size")
-		}
-		switch tag {
-		case uint8ClampedArray:
-			v := make([]byte, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case uint8Array:
-			v := make([]byte, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case int8Array:
-			v := make([]int8, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case int16Array:
-			v := make([]int16, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case uint16Array:
-			v := make([]uint16, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case int32Array:
-			v := make([]int32, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case uint32Array:
-			v := make([]uint32, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case bigInt64Array:
-			v := make([]int64, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case bigUint64Array:
-			v := make([]uint64, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case float32Array:
-			v := make([]float32, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
-		case float64Array:
-			v := make([]float64, n)
-			panicIf(binary.Read(r, binary.LittleEndian, &v))
-			return v
+		d.addBufferBytes(n)
+		b := readBytes(r, n)
+		d.addRef(ArrayBuffer{b})
+		return d.arrayBuffer(b)
+	case tagSharedArrayBuffer:
+		v := readSharedArrayBuffer(r, d.order())
+		d.addRef(v)
+		return v
+	case tagRegExp:
+		v := d.readRegExp()
+		d.addRef(v)
+		return v
+	case tagObjectValue:
+		var v any
+		switch t := d.readWrapped().(type) {
+		case bool:
+			v = BoxedBool{t}
+		case int32:
+			v = BoxedNumber{float64(t)}
+		case float64:
+			v = BoxedNumber{t}
+		case Number:
+			f, err := t.Float64()
+			panicIf(err)
+			v = BoxedNumber{f}
+		case string:
+			v = BoxedString{t}
 		default:
-			panic(fmt.Sprintf("bad typed array tag: %d", tag))
+			panic(fmt.Sprintf("unsupported boxed %T", t))
+		}
+		d.addRef(v)
+		return v
+	case tagDate:
+		var ms float64
+		switch t := d.readWrapped().(type) {
+		case int32:
+			ms = float64(t)
+		case float64:
+			ms = t
+		case Number:
+			f, err := t.Float64()
+			panicIf(err)
+			ms = f
+		default:
+			panic(fmt.Sprintf("unsupported date value %T", t))
+		}
+		v := timeFromMillis(ms)
+		d.addRef(v)
+		return v
+	case tagObjectReference:
+		idx := readUint32(r)
+		if idx >= len(d.refs) {
+			panic(fmt.Sprintf("object reference out of range: %d", idx))
+		}
+		switch v := d.refs[idx].(type) {
+		case incomplete:
+			panic(fmt.Sprintf("unsupported object reference: %d", idx))
+		case pendingArray:
+			d.refs[idx] = pendingArray{referenced: true}
+			return arrayRef(idx)
+		case ArrayBuffer:
+			return d.arrayBuffer(v.Bytes)
+		default:
+			return v
+		}
+	case tagTypedArray:
+		// like QuickJS, number the typed array before its arraybuffer
+		idx := d.addRef(incomplete{})
+		v := d.readTypedArray(readByte(r))
+		d.refs[idx] = v
+		return v
+	default:
+		// Including tags that customized runtimes use for objects that
+		// stock QuickJS refuses to serialize, like Proxy objects: there is
+		// no tag for those, JS_WriteObject() fails with "unsupported
+		// object class".
+		if d.key != "" {
+			panic(fmt.Sprintf("unsupported %s in property %q", TagName(tag), d.key))
+		}
+		panic(fmt.Sprintf("unsupported %s", TagName(tag)))
+	}
+}
+
+// readProperty reads a property name and value. The format only has data
+// properties: QuickJS refuses to serialize accessors ("only value
+// properties are supported") so there is no getter or setter to decode.
+// An unknown tag in place of the value is what a runtime that does
+// serialize them would write.
+func (d *Decoder) readProperty() (string, any) {
+	key := d.readAtom()
+	outer := d.key
+	d.key = key // for error messages; stays at the innermost key on panic
+	var v any
+	if d.lazy {
+		v = d.readRaw()
+	} else if tag := d.readTag(); tag == 0 || tag > tagObjectReference {
+		panic(fmt.Sprintf("accessor properties not supported: %s in property %q", TagName(tag), key))
+	} else {
+		v = d.readBody(tag)
+	}
+	d.key = outer
+	return key, v
+}
+
+// arrayBuffer returns the Go value an ArrayBuffer decodes as.
+func (d *Decoder) arrayBuffer(b []byte) any {
+	if d.WrapArrayBuffers {
+		return ArrayBuffer{b}
+	}
+	return d.maybeString(b)
+}
+
+func (d *Decoder) maybeString(b []byte) any {
+	if d.UTF8Buffers && utf8.Valid(b) {
+		return string(b)
+	}
+	return b
+}
+
+func (d *Decoder) readPropertyCount() int {
+	n := readUint32(d.r)
+	if d.MaxObjectProperties > 0 && n > d.MaxObjectProperties {
+		panic(fmt.Sprintf("object has %d properties, limit is %d", n, d.MaxObjectProperties))
+	}
+	return n
+}
+
+// addElements counts the elements of an array or object against
+// MaxElements before they are read.
+func (d *Decoder) addElements(n int) {
+	d.elements += n
+	if d.MaxElements > 0 && d.elements > d.MaxElements {
+		panic(fmt.Sprintf("more than %d array elements and object properties", d.MaxElements))
+	}
+}
+
+// addBufferBytes counts n bytes of arraybuffer contents toward
+// MaxTypedArrayBytes before they are allocated.
+func (d *Decoder) addBufferBytes(n int) {
+	d.buffers += n
+	if d.MaxTypedArrayBytes > 0 && d.buffers > d.MaxTypedArrayBytes {
+		panic(fmt.Sprintf("more than %d bytes of typed arrays and arraybuffers", d.MaxTypedArrayBytes))
+	}
+}
+
+// incomplete stands in for objects that can't be referenced (yet).
+type incomplete struct{}
+
+// pendingArray stands in for an array of more than maxPrealloc elements
+// while its elements are read. Those can't refer to the array directly
+// because its backing array isn't allocated up front; they get an arrayRef
+// that patchArray replaces once the array is complete.
+type pendingArray struct {
+	referenced bool
+}
+
+type arrayRef int
+
+// patchArray replaces ref with a in v and everything it contains.
+func patchArray(v any, ref arrayRef, a []any, seen map[unsafe.Pointer]bool) {
+	patch := func(e any) any {
+		if e == any(ref) {
+			return a
+		}
+		patchArray(e, ref, a, seen)
+		return e
+	}
+	switch t := v.(type) {
+	case []any:
+		if len(t) == 0 || seen[identity(t)] {
+			return
+		}
+		seen[identity(t)] = true
+		for i, e := range t {
+			t[i] = patch(e)
+		}
+	case map[string]any:
+		if seen[identity(t)] {
+			return
+		}
+		seen[identity(t)] = true
+		for k, e := range t {
+			t[k] = patch(e)
+		}
+	case *Object:
+		if seen[unsafe.Pointer(t)] {
+			return
+		}
+		seen[unsafe.Pointer(t)] = true
+		for i, p := range t.Props {
+			t.Props[i].Value = patch(p.Value)
+		}
+	}
+}
+
+// addRef adds v to the table of objects that tagObjectReference can refer
+// to and returns its index. Objects are numbered in the order QuickJS
+// creates them.
+func (d *Decoder) addRef(v any) int {
+	d.refs = append(d.refs, v)
+	return len(d.refs) - 1
+}
+
+// readTypedArray reads a typed array of the given kind. Its arraybuffer is
+// either inline or a reference to one seen earlier, in which case the
+// slices of both typed arrays share memory, like their JS counterparts.
+// That's also true for the arraybuffer itself when it's referenced.
+func (d *Decoder) readTypedArray(kind byte) any {
+	r := d.r
+	n := readUint32(r)
+	// offset of the view into the arraybuffer, in bytes
+	offset := readUint32(r)
+	var buf []byte
+	switch tag := readByte(r); tag {
+	case tagArrayBuffer:
+		buf = d.readTypedArrayBytes(kind, n, readUint32(r))
+		d.addRef(ArrayBuffer{buf})
+	case tagObjectReference:
+		idx := readUint32(r)
+		if idx >= len(d.refs) {
+			panic(fmt.Sprintf("object reference out of range: %d", idx))
+		}
+		ab, ok := d.refs[idx].(ArrayBuffer)
+		if !ok {
+			panic(fmt.Sprintf("typed array refers to non-arraybuffer object: %d", idx))
+		}
+		buf = ab.Bytes
+	default:
+		panic(&TypedArrayBufferError{tag, d.lastOffset()})
+	}
+	size := checkTypedArrayBounds(kind, n, offset, len(buf))
+	b := buf[offset : offset+size : offset+size]
+	switch kind {
+	case uint8ClampedArray:
+		return Uint8ClampedArray{b}
+	case uint8Array:
+		return d.maybeString(b)
+	case int8Array:
+		return view[int8](b, d.order())
+	case int16Array:
+		return view[int16](b, d.order())
+	case uint16Array:
+		return view[uint16](b, d.order())
+	case int32Array:
+		return view[int32](b, d.order())
+	case uint32Array:
+		return view[uint32](b, d.order())
+	case bigInt64Array:
+		if d.BigIntArrays {
+			return bigInts(view[int64](b, d.order()))
+		}
+		return view[int64](b, d.order())
+	case bigUint64Array:
+		if d.BigIntArrays {
+			return bigInts(view[uint64](b, d.order()))
 		}
+		return view[uint64](b, d.order())
+	case float32Array:
+		return view[float32](b, d.order())
+	case float64Array:
+		return view[float64](b, d.order())
 	default:
-		panic(fmt.Sprintf("unsupported %s", tagName(tag)))
+		panic(fmt.Sprintf("bad typed array tag: %d", kind))
+	}
+}
+
+func bigInts[T int64 | uint64](v []T) []*big.Int {
+	r := make([]*big.Int, len(v))
+	for i, x := range v {
+		if x < 0 {
+			r[i] = big.NewInt(int64(x))
+		} else {
+			r[i] = new(big.Int).SetUint64(uint64(x))
+		}
+	}
+	return r
+}
+
+// checkTypedArrayBounds checks that a typed array of n elements at offset
+// fits in an arraybuffer of buflen bytes, and returns its size in bytes.
+// The arraybuffer may be larger, e.g., when the typed array is a subarray,
+// but never smaller: typed arrays don't have holes.
+func checkTypedArrayBounds(kind byte, n, offset, buflen int) int {
+	size := n * typedArrayElementSize(kind)
+	if offset > buflen || size > buflen-offset {
+		panic(fmt.Sprintf("%s of %d elements (%d bytes) at offset %d exceeds arraybuffer of %d bytes",
+			TypedArrayKindName(kind), n, size, offset, buflen))
+	}
+	return size
+}
+
+// readTypedArrayBytes is readBytes for the inline arraybuffer of a typed
+// array, with more context when the input is short.
+func (d *Decoder) readTypedArrayBytes(kind byte, n, size int) []byte {
+	d.addBufferBytes(size)
+	offset := d.lastOffset()
+	if offset >= 0 {
+		offset++
+	}
+	b, err := readFull(d.r, size)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // the typed array header was read
+		}
+		panic(&TruncatedTypedArrayError{kind, n, offset, len(b), size, err})
 	}
+	return b
+}
+
+// order returns the byte order of the input, see ByteOrder.
+func (d *Decoder) order() binary.ByteOrder {
+	if d.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return d.ByteOrder
+}
+
+// hostLittleEndian is true when the in-memory representation of numbers
+// matches the serialization format.
+var hostLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// view reinterprets b as a slice of T without copying when the host byte
+// order matches order and b's alignment allows it, and decodes a copy
+// when they don't.
+func view[T int8 | int16 | uint16 | int32 | uint32 | int64 | uint64 | float32 | float64](b []byte, order binary.ByteOrder) []T {
+	var zero T
+	n := len(b) / int(unsafe.Sizeof(zero))
+	p := unsafe.Pointer(unsafe.SliceData(b))
+	native := order == binary.LittleEndian && hostLittleEndian || order == binary.BigEndian && !hostLittleEndian
+	if n > 0 && native && uintptr(p)%unsafe.Alignof(zero) == 0 {
+		return unsafe.Slice((*T)(p), n)
+	}
+	v := make([]T, n)
+	panicIf(binary.Read(bytes.NewReader(b), order, v))
+	return v
 }
 
 type byteReader struct {
@@ -331,7 +2306,9 @@ type byteReader struct {
 
 func (br byteReader) ReadByte() (res byte, err error) {
 	var b [1]byte
-	_, err = br.r.Read(b[:])
+	// not br.r.Read(): readers may return 0 bytes and no error, e.g., an
+	// io.Pipe when the other end writes an empty slice
+	_, err = io.ReadFull(br.r, b[:])
 	res = b[0]
 	return
 }
@@ -346,13 +2323,50 @@ func readByte(r io.Reader) byte {
 }
 
 func readBytes(r io.Reader, n int) []byte {
-	b := make([]byte, n)
-	if _, err := r.Read(b); err != nil && n > 0 {
-		panic(err)
+	b, err := readFull(r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // the length was read
 	}
+	panicIf(err)
 	return b
 }
 
+// readFull is io.ReadFull for n bytes, with a buffer that grows as the
+// data arrives because n comes from the input and may be a lie. It
+// returns what it read when there is less than n.
+func readFull(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, 0, preallocLimit(n))
+	for len(b) < n {
+		// don't call r.Read() when n is zero, zero-length reads may have
+		// side effects
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		end := cap(b)
+		if end > n {
+			end = n
+		}
+		m, err := io.ReadFull(r, b[len(b):end])
+		b = b[:len(b)+m]
+		if err == io.EOF && len(b) > 0 {
+			return b, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return b, err
+		}
+	}
+	return b[:n:n], nil
+}
+
+// readUTF16 reads a wide string of n code units.
+func (d *Decoder) readUTF16(n int) []uint16 {
+	b := readBytes(d.r, 2*n)
+	h := make([]uint16, n)
+	for i := range h {
+		h[i] = d.order().Uint16(b[2*i:])
+	}
+	return h
+}
+
 func readUint32(r io.Reader) int {
 	v, err := binary.ReadUvarint(byteReader{r})
 	if err != nil {
@@ -364,44 +2378,324 @@ func readUint32(r io.Reader) int {
 	return int(v)
 }
 
-func readString(r io.Reader) string {
-	n := readUint32(r)
+// readWrapped reads the primitive inside a boxed primitive or a date,
+// which isn't passed to NumberHook or decoded as StringBytes.
+func (d *Decoder) readWrapped() any {
+	defer func(wrapped bool) { d.wrapped = wrapped }(d.wrapped)
+	d.wrapped = true
+	return d.readValue()
+}
+
+// number returns what d.NumberHook makes of a number.
+func (d *Decoder) number(tag byte, raw any) any {
+	v, err := d.NumberHook(tag, raw)
+	panicIf(err)
+	return v
+}
+
+// readStringBytes is readString for StringsAsBytes.
+func (d *Decoder) readStringBytes() StringBytes {
+	n := readUint32(d.r)
+	isWide := (n & 1) == 1
+	n = n >> 1
+	if isWide {
+		h := d.readUTF16(n)
+		b := make([]byte, 0, n)
+		for _, c := range utf16.Decode(h) {
+			b = utf8.AppendRune(b, c)
+		}
+		return b
+	}
+	// narrow strings are latin1 here, also without d.Latin1, because raw
+	// bytes >= 0x80 aren't UTF-8 and would be lost when written back
+	b := readBytes(d.r, n)
+	for i, c := range b {
+		if c >= 0x80 {
+			u := make([]byte, i, n+n-i)
+			copy(u, b)
+			for _, c := range b[i:] {
+				u = utf8.AppendRune(u, rune(c))
+			}
+			return u
+		}
+	}
+	return b
+}
+
+func (d *Decoder) readString() string {
+	n := readUint32(d.r)
 	isWide := (n & 1) == 1
 	n = n >> 1
 	if isWide {
-		h := make([]uint16, n)
-		panicIf(binary.Read(r, binary.LittleEndian, &h))
-		return string(utf16.Decode(h))
+		return string(utf16.Decode(d.readUTF16(n)))
+	} else if d.Latin1 {
+		b := readBytes(d.r, n)
+		u := make([]rune, n)
+		for i, c := range b {
+			u[i] = rune(c)
+		}
+		return string(u)
 	} else {
-		b := readBytes(r, n)
+		b := readBytes(d.r, n)
 		return string(b)
 	}
 }
 
-func setField(ptr any, name string, value any) bool {
-	pv := reflect.ValueOf(ptr).Elem()
-	field, ok := pv.Type().FieldByName(name)
+// setField sets the field that property name maps to in addressable
+// struct sv, if any.
+func (d *Decoder) setField(sv reflect.Value, name string, value any) bool {
+	field, ok := CompileSchema(sv.Type()).field(name, d.CaseInsensitive)
+	if ok && d.patch && value == Undefined {
+		return ok
+	}
 	if ok {
-		fv := pv.FieldByIndex(field.Index)
+		fv := sv.FieldByIndex(field.Index)
 		fp := unsafe.Pointer(fv.UnsafeAddr())
 		fv = reflect.NewAt(fv.Type(), fp).Elem()
-		vv := reflect.ValueOf(value)
-		if vv.IsValid() {
-			fv.Set(vv)
+		if d.CollectWarnings {
+			d.tryAssign(fv, value)
 		} else {
-			fv.SetZero()
+			d.assign(fv, value)
 		}
 	}
 	return ok
 }
 
+// tryAssign is assign with failures turned into warnings.
+func (d *Decoder) tryAssign(fv reflect.Value, value any) {
+	defer func() {
+		if x := recover(); x != nil {
+			s, ok := x.(string)
+			if !ok {
+				panic(x)
+			}
+			d.warn("%s", s)
+		}
+	}()
+	d.assign(fv, value)
+}
+
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("js"), ",")
+	switch name {
+	case "-":
+		return "" // not a valid atom
+	case "":
+		return f.Name
+	}
+	return name
+}
+
+// assign stores value in fv. Numbers are converted to the field's numeric
+// type if they fit without loss.
+func (d *Decoder) assign(fv reflect.Value, value any) {
+	vv := reflect.ValueOf(value)
+	switch {
+	case !vv.IsValid():
+		fv.SetZero()
+	case fv.Kind() == reflect.String && vv.Kind() == reflect.String:
+		d.checkEnum(fv.Type(), vv.String())
+		fv.SetString(vv.String())
+	case fv.Kind() == reflect.String && vv.Type() == reflect.TypeOf(StringBytes(nil)):
+		d.assign(fv, string(value.(StringBytes)))
+	case vv.Type().AssignableTo(fv.Type()):
+		fv.Set(vv)
+	case vv.Type() == reflect.TypeOf(Number("")) && isNumeric(fv.Kind()):
+		// UseNumber
+		if n, err := value.(Number).Int64(); err == nil {
+			d.assign(fv, n)
+		} else {
+			f, err := value.(Number).Float64()
+			panicIf(err)
+			d.assign(fv, f)
+		}
+	case isNumeric(vv.Kind()) && isNumeric(fv.Kind()):
+		assignNumber(fv, vv)
+		if d.CollectWarnings && isFloat(vv.Kind()) && !isFloat(fv.Kind()) {
+			d.warn("converted %s %s to %s", vv.Type(), numberString(vv), fv.Type())
+		}
+	case fv.Kind() == reflect.Struct && d.assignStruct(fv, value):
+	case fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct && isObject(value):
+		// nil pointers to optional sections are allocated on demand, and
+		// only stored once the section is decoded
+		p := fv
+		if fv.IsNil() {
+			p = reflect.New(fv.Type().Elem())
+		}
+		d.assignStruct(p.Elem(), value)
+		fv.Set(p)
+	case fv.Kind() == reflect.Slice && vv.Kind() == reflect.Slice:
+		// element-wise, e.g., []any to []string or []int16 to []int
+		sv := reflect.MakeSlice(fv.Type(), vv.Len(), vv.Len())
+		for i := 0; i < vv.Len(); i++ {
+			d.assign(sv.Index(i), vv.Index(i).Interface())
+		}
+		fv.Set(sv)
+	case fv.Kind() == reflect.Map && fv.Type().Key().Kind() == reflect.String && d.assignMap(fv, value):
+	default:
+		panic(fmt.Sprintf("cannot store %s in %s field", vv.Type(), fv.Type()))
+	}
+}
+
+// checkEnum fails when t is in d.Enums and s isn't one of its values.
+func (d *Decoder) checkEnum(t reflect.Type, s string) {
+	values, ok := d.Enums[t]
+	if !ok {
+		return
+	}
+	for _, v := range values {
+		if v == s {
+			return
+		}
+	}
+	panic(fmt.Sprintf("%q is not a valid %s", s, t))
+}
+
+// assignStruct sets the fields of a nested struct from a decoded object.
+// Fields without a matching property keep their value, like at the top
+// level.
+func (d *Decoder) assignStruct(fv reflect.Value, value any) bool {
+	switch t := value.(type) {
+	case map[string]any:
+		for k, v := range t {
+			d.setField(fv, k, v)
+		}
+	case *Object:
+		for _, p := range t.Props {
+			d.setField(fv, p.Key, p.Value)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func isObject(v any) bool {
+	switch v.(type) {
+	case map[string]any, *Object:
+		return true
+	}
+	return false
+}
+
+// assignMap sets a map field to a new map with the properties of a
+// decoded object, converting each value to the map's element type.
+func (d *Decoder) assignMap(fv reflect.Value, value any) bool {
+	t := fv.Type()
+	m := reflect.MakeMap(t)
+	set := func(k string, v any) {
+		ev := reflect.New(t.Elem()).Elem()
+		d.assign(ev, v)
+		m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+	}
+	switch o := value.(type) {
+	case map[string]any:
+		for k, v := range o {
+			set(k, v)
+		}
+	case *Object:
+		for _, p := range o.Props {
+			set(p.Key, p.Value)
+		}
+	default:
+		return false
+	}
+	fv.Set(m)
+	return true
+}
+
+func assignNumber(fv, vv reflect.Value) {
+	if k := fv.Kind(); k == reflect.Float32 || k == reflect.Float64 {
+		if vv.CanFloat() {
+			fv.SetFloat(vv.Float())
+		} else if vv.CanInt() {
+			fv.SetFloat(float64(vv.Int()))
+		} else {
+			fv.SetFloat(float64(vv.Uint()))
+		}
+		return
+	}
+	if fv.CanUint() && vv.CanUint() {
+		// not through int64, which can't hold values >= 2^63
+		if u := vv.Uint(); !fv.OverflowUint(u) {
+			fv.SetUint(u)
+			return
+		}
+		panic(fmt.Sprintf("cannot store %s in %s field", numberString(vv), fv.Kind()))
+	}
+	var n int64
+	ok := true
+	if vv.CanInt() {
+		n = vv.Int()
+	} else if vv.CanUint() {
+		n = int64(vv.Uint())
+		ok = n >= 0
+	} else {
+		f := vv.Float()
+		n = int64(f)
+		ok = f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+	}
+	if fv.CanInt() {
+		ok = ok && !fv.OverflowInt(n)
+	} else {
+		ok = ok && n >= 0 && !fv.OverflowUint(uint64(n))
+	}
+	if !ok {
+		panic(fmt.Sprintf("cannot store %s in %s field", numberString(vv), fv.Kind()))
+	}
+	if fv.CanInt() {
+		fv.SetInt(n)
+	} else {
+		fv.SetUint(uint64(n))
+	}
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumeric(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64 && k != reflect.Uintptr
+}
+
+// numberString formats like JS's Number.prototype.toString() for the
+// special values.
+func numberString(vv reflect.Value) string {
+	if !vv.CanFloat() {
+		return fmt.Sprint(vv)
+	}
+	switch f := vv.Float(); {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// recoverError turns a panic into an error return. Must be deferred.
+func recoverError(err *error, fn string) {
+	if x := recover(); x != nil {
+		switch v := x.(type) {
+		case error:
+			*err = v
+		default:
+			*err = fmt.Errorf("%s: %v", fn, v)
+		}
+	}
+}
+
 func panicIf(err error) {
 	if err != nil {
 		panic(err)
 	}
 }
 
-func tagName(tag byte) string {
+// TagName returns a human-readable name for a serialization tag.
+func TagName(tag byte) string {
 	switch tag {
 	case tagNull:
 		return "null"
@@ -446,3 +2740,33 @@ func tagName(tag byte) string {
 	}
 	return fmt.Sprintf("unknown tag %d", tag)
 }
+
+// TypedArrayKindName returns the JS class name for a typed array kind,
+// the byte that follows the typed array tag.
+func TypedArrayKindName(kind byte) string {
+	switch kind {
+	case uint8ClampedArray:
+		return "Uint8ClampedArray"
+	case int8Array:
+		return "Int8Array"
+	case uint8Array:
+		return "Uint8Array"
+	case int16Array:
+		return "Int16Array"
+	case uint16Array:
+		return "Uint16Array"
+	case int32Array:
+		return "Int32Array"
+	case uint32Array:
+		return "Uint32Array"
+	case bigInt64Array:
+		return "BigInt64Array"
+	case bigUint64Array:
+		return "BigUint64Array"
+	case float32Array:
+		return "Float32Array"
+	case float64Array:
+		return "Float64Array"
+	}
+	return fmt.Sprintf("unknown typed array kind %d", kind)
+}
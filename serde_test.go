@@ -16,9 +16,23 @@ package serde
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestReadValue(t *testing.T) {
@@ -43,6 +57,662 @@ func TestReadValue(t *testing.T) {
 	expect(map[string]any{"-42": nil}, tryReadValue([]byte{bcVersion, 1, 6, 45, 52, 50, 8, 1, 2, 1}))
 }
 
+type emptyReadCounter struct {
+	r io.Reader
+	n int
+}
+
+func (c *emptyReadCounter) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		c.n++
+	}
+	return c.r.Read(b)
+}
+
+func TestReadEmptyBuffer(t *testing.T) {
+	for _, blob := range [][]byte{
+		{bcVersion, 0, 15, 0},
+		{bcVersion, 0, 7, 0},
+		{bcVersion, 0, 14, 2, 0, 0, 15, 0},
+	} {
+		c := &emptyReadCounter{r: bytes.NewReader(blob)}
+		_, err := ReadValue(c)
+		expect(nil, err)
+		expect(0, c.n)
+	}
+}
+
+func TestReadArrayLyingCount(t *testing.T) {
+	// claims 2^32-1 elements but only has one
+	blob := []byte{bcVersion, 0, 9, 255, 255, 255, 255, 15, 1}
+	_, err := ReadValue(bytes.NewReader(blob))
+	expect(io.EOF, err)
+}
+
+func TestReadLyingLength(t *testing.T) {
+	// lengths of 2^32-1 or 2^32-2 without the data, each would allocate
+	// gigabytes if it was trusted
+	lie := []byte{255, 255, 255, 255, 15}
+	even := []byte{254, 255, 255, 255, 15}
+	for _, c := range []struct {
+		blob []byte
+		want error
+	}{
+		{append([]byte{bcVersion}, lie...), io.ErrUnexpectedEOF},
+		{append([]byte{bcVersion, 0, tagString}, lie...), io.ErrUnexpectedEOF},
+		{append([]byte{bcVersion, 0, tagString}, even...), io.ErrUnexpectedEOF},
+		{append([]byte{bcVersion, 0, tagBigInt}, lie...), io.ErrUnexpectedEOF},
+		{append([]byte{bcVersion, 0, tagArrayBuffer}, lie...), io.ErrUnexpectedEOF},
+		{append([]byte{bcVersion, 0, tagTypedArray, uint8Array, 0, 0, tagArrayBuffer}, lie...), io.ErrUnexpectedEOF},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ReadValue(bytes.NewReader(append(c.blob, 'x')))
+		runtime.ReadMemStats(&after)
+		expect(true, errors.Is(err, c.want))
+		expect(true, after.TotalAlloc-before.TotalAlloc < 1<<20)
+	}
+}
+
+func TestFloat64NaNBits(t *testing.T) {
+	for _, bits := range []uint64{
+		0x7FF0000000000001, // signaling NaN
+		0x7FF8000000000000, // quiet NaN
+		0xFFF800000000BEEF, // negative quiet NaN with payload
+		0x7FF4000000000000,
+	} {
+		blob := []byte{bcVersion, 0, tagFloat64, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(blob[3:], bits)
+		v := tryReadValue(blob).(float64)
+		expect(bits, math.Float64bits(v))
+		expect(blob, tryWriteValue(v))
+	}
+}
+
+func TestReadUTF8Buffers(t *testing.T) {
+	read := func(b []byte) any {
+		return tryDecode(b, func(d *Decoder) { d.UTF8Buffers = true })
+	}
+	expect("héllo", read(tryWriteValue(ArrayBuffer{[]byte("héllo")})))
+	expect("héllo", read(tryWriteValue([]byte("héllo"))))
+	expect([]byte{0xff, 0xfe}, read(tryWriteValue(ArrayBuffer{[]byte{0xff, 0xfe}})))
+	expect([]byte{0xff, 0xfe}, read(tryWriteValue([]byte{0xff, 0xfe})))
+	expect([]byte("ok"), tryReadValue(tryWriteValue([]byte("ok"))))
+}
+
+func TestBoxedPrimitives(t *testing.T) {
+	expect(BoxedBool{true}, tryReadValue([]byte{bcVersion, 0, 19, 4}))
+	expect(BoxedNumber{42}, tryReadValue([]byte{bcVersion, 0, 19, 5, 84}))
+	expect(BoxedNumber{13.37}, tryReadValue([]byte{bcVersion, 0, 19, 6, 61, 10, 215, 163, 112, 189, 42, 64}))
+	expect(BoxedString{"ok"}, tryReadValue([]byte{bcVersion, 0, 19, 7, 4, 111, 107}))
+	expect([]byte{bcVersion, 0, 19, 3}, tryWriteValue(BoxedBool{false}))
+	expect([]byte{bcVersion, 0, 19, 5, 84}, tryWriteValue(BoxedNumber{42}))
+	expect([]byte{bcVersion, 0, 19, 7, 4, 111, 107}, tryWriteValue(BoxedString{"ok"}))
+	for _, v := range []any{BoxedBool{true}, BoxedNumber{-0.5}, BoxedString{"é"}} {
+		expect(v, tryReadValue(tryWriteValue(v)))
+	}
+	// primitives and their wrappers are distinct
+	expect([]any{true, BoxedBool{true}}, tryReadValue(tryWriteValue([]any{true, BoxedBool{true}})))
+	_, err := ReadValue(bytes.NewReader([]byte{bcVersion, 0, 19, 1}))
+	expect("serde.ReadValue: unsupported boxed <nil>", err.Error())
+}
+
+func TestLossless(t *testing.T) {
+	// {z: 1, a: 2.0, m: [undefined, -0, "s"], b: {y: new ArrayBuffer(1), x: new Uint8Array(1)}}
+	in := []byte{bcVersion, 6, 2, 'z', 2, 'a', 2, 'm', 2, 'b', 2, 'y', 2, 'x',
+		8, 4,
+		2, 5, 2,
+		4, 6, 0, 0, 0, 0, 0, 0, 0, 0x40,
+		6, 9, 3, 2, 6, 0, 0, 0, 0, 0, 0, 0, 0x80, 7, 2, 's',
+		8, 8, 2, 10, 15, 1, 0, 12, 14, 2, 1, 0, 15, 1, 0}
+	v := tryDecode(in, func(d *Decoder) {
+		d.OrderedObjects = true
+		d.WrapArrayBuffers = true
+	})
+	o := v.(*Object)
+	expect([]string{"z", "a", "m", "b"}, []string{o.Props[0].Key, o.Props[1].Key, o.Props[2].Key, o.Props[3].Key})
+	expect(int32(1), o.Props[0].Value)
+	expect(2.0, o.Props[1].Value)
+	expect(Undefined, o.Props[2].Value.([]any)[0])
+	s, _ := GetString(v, "m[2]")
+	expect("s", s)
+	expect(in, tryWriteValue(v))
+	// with lazy decoding too
+	v = tryDecode(in, func(d *Decoder) {
+		d.OrderedObjects = true
+		d.Lazy = true
+	})
+	expect(in, tryWriteValue(v))
+}
+
+func TestReadBadProperty(t *testing.T) {
+	// {k: <garbage>}, e.g., from a runtime that serializes accessors
+	_, err := ReadValue(bytes.NewReader([]byte{bcVersion, 1, 2, 'k', 8, 1, 2, 0xff, 0, 0}))
+	expect(`serde.ReadValue: accessor properties not supported: unknown tag 255 in property "k"`, err.Error())
+	// {a: [{b: 1}, <garbage>]}
+	blob := []byte{bcVersion, 2, 2, 'a', 2, 'b', 8, 1, 2, 9, 2, 8, 1, 4, 5, 2, 0}
+	_, err = ReadValue(bytes.NewReader(blob))
+	expect(`serde.ReadValue: unsupported unknown tag 0 in property "a"`, err.Error())
+	err = ReadObject(bytes.NewReader(blob), &struct{}{})
+	expect(`serde.ReadObject: unsupported unknown tag 0 in property "a"`, err.Error())
+	_, err = ReadValue(bytes.NewReader([]byte{bcVersion, 0, 0}))
+	expect(`serde.ReadValue: unsupported unknown tag 0`, err.Error())
+}
+
+func TestReadProxyMarker(t *testing.T) {
+	// {p: <proxy>} from a runtime that marks Proxy objects with a tag
+	// past the last one stock QuickJS uses; the target follows
+	blob := []byte{bcVersion, 1, 2, 'p', tagObject, 1, 2, tagObjectReference + 1, tagObject, 0}
+	// which can't be told apart from an accessor
+	_, err := ReadValue(bytes.NewReader(blob))
+	expect(`serde.ReadValue: accessor properties not supported: unknown tag 21 in property "p"`, err.Error())
+	_, err = ReadValue(bytes.NewReader([]byte{bcVersion, 0, tagArray, 1, tagObjectReference + 1, tagObject, 0}))
+	expect(`serde.ReadValue: unsupported unknown tag 21`, err.Error())
+	err = Validate(bytes.NewReader(blob))
+	expect(`serde.Validate: unsupported unknown tag 21`, err.Error())
+	s, err := Dump(blob)
+	expect(`serde.Dump: unsupported unknown tag 21`, err.Error())
+	expect(true, strings.HasSuffix(s, "\"p\": unknown tag 21\n"))
+}
+
+func TestReadCompactObject(t *testing.T) {
+	// there is no compact object form; {a: 1} in a made-up inline
+	// encoding is an unknown tag
+	blob := []byte{bcVersion, 1, 2, 'a', tagObjectReference + 1, 2, tagInt32, 2}
+	_, err := ReadValue(bytes.NewReader(blob))
+	expect("serde.ReadValue: unsupported unknown tag 21", err.Error())
+	// and a blob from another version is rejected before its tags are
+	// looked at
+	blob[0] = bcVersion + 1
+	_, err = ReadValue(bytes.NewReader(blob))
+	var vme *VersionMismatchError
+	expect(true, errors.As(err, &vme))
+	err = Validate(bytes.NewReader(blob))
+	expect(true, errors.As(err, &vme))
+}
+
+func TestReadObjectSymbolKeys(t *testing.T) {
+	// {a: 1, [Symbol("s")]: 2} is written without its symbol property,
+	// there is no wire form for symbol keys to skip or rename
+	blob := []byte{bcVersion, 1, 2, 'a', tagObject, 1, 2, tagInt32, 2}
+	expect(map[string]any{"a": int32(1)}, tryReadValue(blob))
+	expect(blob, tryWriteValue(map[string]any{"a": int32(1)}))
+	// an atom index that isn't in the atom table fails, symbol or not
+	blob = []byte{bcVersion, 1, 2, 'a', tagObject, 2, 2, tagInt32, 2, 4, tagInt32, 4}
+	_, err := ReadValue(bytes.NewReader(blob))
+	expect("serde.ReadValue: atom out of range", err.Error())
+}
+
+func TestReadObjectReference(t *testing.T) {
+	// a = []; a.push(a)
+	a := tryReadValue([]byte{bcVersion, 0, 9, 1, 20, 0}).([]any)
+	expect(1, len(a))
+	expect(&a[0], &a[0].([]any)[0])
+	// o = {}; o.k = o
+	o := tryReadValue([]byte{bcVersion, 1, 2, 107, 8, 1, 2, 20, 0}).(map[string]any)
+	expect(reflect.ValueOf(o).UnsafePointer(), reflect.ValueOf(o["k"]).UnsafePointer())
+	// o = {}; [o, [o]]
+	b := tryReadValue([]byte{bcVersion, 0, 9, 2, 8, 0, 9, 1, 20, 1}).([]any)
+	expect(reflect.ValueOf(b[0]).UnsafePointer(), reflect.ValueOf(b[1].([]any)[0]).UnsafePointer())
+	// a = new Array(5000).fill(null); a[0] = a; a[1] = {k: a}; a[4999] = a
+	blob := []byte{bcVersion, 1, 2, 'k', 9, 0x88, 0x27, 20, 0, 8, 1, 2, 20, 0}
+	blob = append(blob, bytes.Repeat([]byte{1}, 4997)...)
+	blob = append(blob, 20, 0)
+	a = tryReadValue(blob).([]any)
+	expect(5000, len(a))
+	expect(&a[0], &a[0].([]any)[0])
+	expect(&a[0], &a[1].(map[string]any)["k"].([]any)[0])
+	expect(&a[0], &a[4999].([]any)[0])
+	expect(nil, a[2])
+	_, err := ReadValue(bytes.NewReader([]byte{bcVersion, 0, 9, 1, 20, 1}))
+	expect("serde.ReadValue: object reference out of range: 1", err.Error())
+	// o = {}; {a: o, b: o}, where #0 is the outer object
+	blob = []byte{bcVersion, 2, 2, 'a', 2, 'b', 8, 2, 2, 8, 0, 4, 20, 1}
+	var s struct {
+		A map[string]any `js:"a"`
+		B map[string]any `js:"b"`
+	}
+	expect(nil, ReadObject(bytes.NewReader(blob), &s))
+	expect(map[string]any{}, s.A)
+	expect(reflect.ValueOf(s.A).UnsafePointer(), reflect.ValueOf(s.B).UnsafePointer())
+	err = ReadObject(bytes.NewReader([]byte{bcVersion, 1, 2, 'a', 8, 1, 2, 20, 0}), &s)
+	expect("serde.ReadObject: unsupported object reference: 0", err.Error())
+}
+
+func TestReadMaxObjectProperties(t *testing.T) {
+	// claims 2^32-1 properties but has none
+	blob := []byte{bcVersion, 0, 8, 255, 255, 255, 255, 15}
+	d := NewDecoder(bytes.NewReader(blob))
+	d.MaxObjectProperties = 1000
+	_, err := d.Decode()
+	expect("serde.ReadValue: object has 4294967295 properties, limit is 1000", err.Error())
+	d = NewDecoder(bytes.NewReader(blob))
+	d.MaxObjectProperties = 1000
+	err = d.DecodeObject(&struct{}{})
+	expect("serde.ReadObject: object has 4294967295 properties, limit is 1000", err.Error())
+	blob = tryWriteValue(map[string]any{"a": nil, "b": nil})
+	d = NewDecoder(bytes.NewReader(blob))
+	d.MaxObjectProperties = 2
+	v, err := d.Decode()
+	expect(nil, err)
+	expect(map[string]any{"a": nil, "b": nil}, v)
+}
+
+func TestReadLatin1(t *testing.T) {
+	blob := []byte{bcVersion, 0, 7, 2, 0xE9}
+	expect("\xe9", tryReadValue(blob))
+	expect("é", tryDecode(blob, func(d *Decoder) { d.Latin1 = true }))
+}
+
+func TestReadWideAtoms(t *testing.T) {
+	// {"café": 1, "ключ": 2, "🔑": 3} with utf16 atoms
+	blob := []byte{bcVersion, 3,
+		9, 'c', 0, 'a', 0, 'f', 0, 0xE9, 0,
+		9, 0x3A, 4, 0x3B, 4, 0x4E, 4, 0x47, 4,
+		5, 0x3D, 0xD8, 0x11, 0xDD,
+		tagObject, 3, 2, 5, 2, 4, 5, 4, 6, 5, 6}
+	want := map[string]any{"café": int32(1), "ключ": int32(2), "🔑": int32(3)}
+	expect(want, tryReadValue(blob))
+	expect(blob, tryWriteValue(want))
+	type T struct {
+		Cafe int `js:"café"`
+		Key  int `js:"ключ"`
+		Ключ int `js:"-"`
+		X    int `js:"🔑"`
+	}
+	expect(&T{Cafe: 1, Key: 2, X: 3}, tryReadObject(&T{}, blob))
+	type U struct {
+		КЛЮЧ int
+		CAFÉ int
+	}
+	d := NewDecoder(bytes.NewReader(blob))
+	d.CaseInsensitive = true
+	have := &U{}
+	expect(nil, d.DecodeObject(have))
+	expect(&U{КЛЮЧ: 2, CAFÉ: 1}, have)
+	// narrow atoms are latin1 in QuickJS
+	narrow := []byte{bcVersion, 1, 8, 'c', 'a', 'f', 0xE9, tagObject, 1, 2, 5, 2}
+	d = NewDecoder(bytes.NewReader(narrow))
+	d.Latin1 = true
+	have2 := &T{}
+	expect(nil, d.DecodeObject(have2))
+	expect(&T{Cafe: 1}, have2)
+}
+
+func TestStringsAsBytes(t *testing.T) {
+	asBytes := func(d *Decoder) { d.StringsAsBytes = true }
+	v := map[string]any{
+		"a":      "narrow",
+		"\u2603": "wide \u2603 \U0001F511",
+		"b":      []any{"", BoxedString{"boxed"}},
+	}
+	blob := tryWriteValue(v)
+	have := tryDecode(blob, asBytes)
+	expect(map[string]any{
+		"a":      StringBytes("narrow"),
+		"\u2603": StringBytes("wide \u2603 \U0001F511"),
+		"b":      []any{StringBytes{}, BoxedString{"boxed"}},
+	}, have)
+	expect(blob, tryWriteValue(have))
+	latin1 := []byte{bcVersion, 0, tagString, 2, 0xE9}
+	// narrow strings are latin1 so that they survive being written back
+	expect(StringBytes("é"), tryDecode(latin1, asBytes))
+	expect(StringBytes("é"), tryDecode(latin1, func(d *Decoder) {
+		d.StringsAsBytes = true
+		d.Latin1 = true
+	}))
+	mixed := []byte{bcVersion, 0, tagString, 8, 'a', 0xE9, 'b', 0xFF}
+	have = tryDecode(mixed, asBytes)
+	expect(StringBytes("aébÿ"), have)
+	expect("aébÿ", tryReadValue(tryWriteValue(have)))
+	expect(tryWriteValue("aébÿ"), tryWriteValue(have))
+	type T struct {
+		A string
+		B []byte
+	}
+	d := NewDecoder(bytes.NewReader(tryWriteValue(map[string]any{"A": "x", "B": "y"})))
+	d.StringsAsBytes = true
+	obj := &T{}
+	expect(nil, d.DecodeObject(obj))
+	expect(&T{A: "x", B: []byte("y")}, obj)
+}
+
+func BenchmarkStringsAsBytes(b *testing.B) {
+	m := map[string]any{}
+	for i := 0; i < 1000; i++ {
+		m[fmt.Sprintf("key%d", i)] = fmt.Sprintf("some value of medium length %d", i)
+	}
+	blob := tryWriteValue(m)
+	for _, asBytes := range []bool{false, true} {
+		b.Run(fmt.Sprintf("StringsAsBytes=%v", asBytes), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(blob)))
+			for i := 0; i < b.N; i++ {
+				d := NewDecoder(bytes.NewReader(blob))
+				d.StringsAsBytes = asBytes
+				if _, err := d.Decode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadNumber(t *testing.T) {
+	read := func(b []byte) any {
+		return tryDecode(b, func(d *Decoder) { d.UseNumber = true })
+	}
+	expect(Number("2147483647"), read(tryWriteValue(int32(math.MaxInt32))))
+	expect(Number("-2147483648"), read(tryWriteValue(int32(math.MinInt32))))
+	expect(Number("13.37"), read(tryWriteValue(13.37)))
+	expect(Number("-0"), read(tryWriteValue(math.Copysign(0, -1))))
+	expect(int32(math.MaxInt32), tryReadValue(tryWriteValue(Number("2147483647"))))
+	expect(13.37, tryReadValue(tryWriteValue(Number("13.37"))))
+	expect(2147483648.0, tryReadValue(tryWriteValue(Number("2147483648"))))
+	expect(math.Copysign(0, -1), tryReadValue(tryWriteValue(Number("-0"))))
+	// DecodeObject stores numbers in numeric fields, and in any and
+	// Number fields as Number
+	type T struct {
+		I int
+		U uint8
+		F float32
+		A any
+		N Number
+	}
+	decode := func(v any) (*T, error) {
+		d := NewDecoder(bytes.NewReader(tryWriteValue(v)))
+		d.UseNumber = true
+		have := &T{}
+		return have, d.DecodeObject(have)
+	}
+	have, err := decode(map[string]any{"I": int32(-7), "U": 3.0, "F": 1.5, "A": int32(1), "N": 2.5})
+	expect(nil, err)
+	expect(&T{I: -7, U: 3, F: 1.5, A: Number("1"), N: Number("2.5")}, have)
+	_, err = decode(map[string]any{"I": 1.5})
+	expect("serde.ReadObject: cannot store 1.5 in int field", err.Error())
+	_, err = decode(map[string]any{"U": int32(256)})
+	expect("serde.ReadObject: cannot store 256 in uint8 field", err.Error())
+}
+
+type cents int64
+
+func TestNumberHook(t *testing.T) {
+	tags := []byte{}
+	hook := func(tag byte, raw any) (any, error) {
+		tags = append(tags, tag)
+		switch v := raw.(type) {
+		case int32:
+			return cents(v) * 100, nil
+		case float64:
+			if math.IsNaN(v) {
+				return nil, errors.New("not a price")
+			}
+			return cents(math.Round(v * 100)), nil
+		}
+		panic("unreachable")
+	}
+	blob := tryWriteValue(map[string]any{"a": int32(2), "b": 0.1, "c": "3.00", "d": []any{1.5}})
+	v := tryDecode(blob, func(d *Decoder) {
+		d.NumberHook = hook
+		d.UseNumber = true
+		d.NarrowFloats = true
+	})
+	expect(map[string]any{"a": cents(200), "b": cents(10), "c": "3.00", "d": []any{cents(150)}}, v)
+	expect(3, len(tags))
+	expect(2, bytes.Count(tags, []byte{tagFloat64}))
+	type T struct {
+		Price cents
+		Name  string
+	}
+	d := NewDecoder(bytes.NewReader(tryWriteValue(map[string]any{"Price": 19.99, "Name": "x"})))
+	d.NumberHook = hook
+	have := &T{}
+	expect(nil, d.DecodeObject(have))
+	expect(&T{Price: 1999, Name: "x"}, have)
+	// not for numbers that are part of something else
+	v = tryDecode(tryWriteValue([]any{BoxedNumber{2}, time.UnixMilli(3)}), func(d *Decoder) { d.NumberHook = hook })
+	expect([]any{BoxedNumber{2}, time.UnixMilli(3).UTC()}, v)
+	_, err := NewDecoder(bytes.NewReader(tryWriteValue(math.NaN()))).Decode()
+	expect(nil, err)
+	d = NewDecoder(bytes.NewReader(tryWriteValue([]any{math.NaN()})))
+	d.NumberHook = hook
+	_, err = d.Decode()
+	expect(errors.New("not a price"), err)
+}
+
+func TestReadValueRaw(t *testing.T) {
+	a := tryWriteValue(map[string]any{"k": []any{"v", 1.5, []int16{1, 2}}})
+	b := tryWriteValue("\u2603")
+	br := bytes.NewReader(append(append([]byte{}, a...), b...))
+	v, raw, err := ReadValueRaw(br)
+	expect(nil, err)
+	expect(a, raw)
+	expect(v, tryReadValue(raw))
+	v, raw, err = ReadValueRaw(br)
+	expect(nil, err)
+	expect(b, raw)
+	expect("\u2603", v)
+	_, raw, err = ReadValueRaw(bytes.NewReader(a[:5]))
+	expect(io.EOF, err)
+	expect(a[:5], raw)
+}
+
+func TestReadValues(t *testing.T) {
+	blobs := [][]byte{
+		tryWriteValue("a"),
+		tryWriteValue(map[string]any{"k": int32(1)}),
+		tryWriteValue([]any{true, nil}),
+	}
+	data := bytes.Join(blobs, nil)
+	vs, err := ReadValues(bytes.NewReader(data))
+	expect(nil, err)
+	expect([]any{"a", map[string]any{"k": int32(1)}, []any{true, nil}}, vs)
+	vs, err = ReadValues(bytes.NewReader(nil))
+	expect(nil, err)
+	expect([]any{}, vs)
+	vs, err = ReadValues(bytes.NewReader(data[:len(data)-1]))
+	expect(io.ErrUnexpectedEOF, err)
+	expect(2, len(vs))
+}
+
+func TestReadValueN(t *testing.T) {
+	a := tryWriteValue("a")
+	b := tryWriteValue(int32(42))
+	br := bytes.NewReader(append(append(a, b...), 0xff))
+	v, err := ReadValueN(br, len(a))
+	expect(nil, err)
+	expect("a", v)
+	_, err = ReadValueN(br, len(b)+1)
+	expect(fmt.Sprintf("serde.ReadValueN: value is %d bytes, expected %d", len(b), len(b)+1), err.Error())
+	expect(0, br.Len()) // skipped the trailing byte
+	br = bytes.NewReader(append(a, b...))
+	_, err = ReadValueN(br, len(a)-1)
+	expect(fmt.Sprintf("serde.ReadValueN: value is longer than %d bytes", len(a)-1), err.Error())
+	expect(len(b)+1, br.Len())
+	_, err = ReadValueN(bytes.NewReader(a[:2]), len(a))
+	expect(io.ErrUnexpectedEOF, err)
+	_, err = ReadValueN(bytes.NewReader(nil), len(a))
+	expect(io.EOF, err)
+}
+
+func TestReadAtoms(t *testing.T) {
+	br := bytes.NewReader([]byte{bcVersion, 2, 2, 97, 2, 98, 8, 2, 2, 1, 4, 1})
+	atoms, err := ReadAtoms(br)
+	expect(nil, err)
+	expect([]string{"a", "b"}, atoms)
+	expect(6, br.Len()) // positioned at the object
+}
+
+func TestRenameAtoms(t *testing.T) {
+	v := map[string]any{
+		"email": "a@example.com",
+		"name":  "x",
+		"nested": []any{
+			map[string]any{"email": "b@example.com", "0": int32(1)},
+		},
+	}
+	blob := tryWriteValue(v)
+	renamed, err := RenameAtoms(blob, map[string]string{"email": "\u2603", "0": "zero", "missing": "m"})
+	expect(nil, err)
+	expect(map[string]any{
+		"\u2603": "a@example.com",
+		"name":   "x",
+		"nested": []any{
+			map[string]any{"\u2603": "b@example.com", "0": int32(1)},
+		},
+	}, tryReadValue(renamed))
+	same, err := RenameAtoms(blob, nil)
+	expect(nil, err)
+	expect(blob, same)
+	_, err = RenameAtoms([]byte{bcVersion, 2, 2, 97}, nil)
+	expect(io.EOF, err)
+	_, err = RenameAtoms([]byte{1, 0}, nil)
+	expect(&VersionMismatchError{1, bcVersion}, err)
+}
+
+func TestInspect(t *testing.T) {
+	for _, c := range []struct {
+		v    any
+		want Info
+	}{
+		{map[string]any{"a": int32(1), "b": []any{"c"}, "42": nil}, Info{tagObject, 3, 2}},
+		{[]any{map[string]any{"k": true}, int32(2)}, Info{tagArray, 2, 1}},
+		{[]float64{1, 2, 3}, Info{tagTypedArray, 3, 0}},
+		{ArrayBuffer{[]byte{1, 2}}, Info{tagArrayBuffer, 2, 0}},
+		{"\u2603x", Info{tagString, 2, 0}},
+		{int32(5), Info{tagInt32, 0, 0}},
+	} {
+		br := bytes.NewReader(tryWriteValue(c.v))
+		info, err := Inspect(br)
+		expect(nil, err)
+		expect(c.want, info)
+	}
+	_, err := Inspect(bytes.NewReader([]byte{bcVersion, 0}))
+	expect(io.EOF, err)
+}
+
+func TestDecoderPool(t *testing.T) {
+	blob := tryWriteValue(map[string]any{"k": "v", "n": []any{int32(1), "x"}})
+	want := map[string]any{"k": "v", "n": []any{int32(1), "x"}}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				d := GetDecoder(bytes.NewReader(blob))
+				v, err := d.Decode()
+				PutDecoder(d)
+				expect(nil, err)
+				expect(want, v)
+			}
+		}()
+	}
+	wg.Wait()
+	// pooled decoders hold on to nothing from their last input
+	d := NewDecoder(bytes.NewReader(blob))
+	d.InternObjects = true
+	d.CollectWarnings = true
+	_, err := d.Decode()
+	expect(nil, err)
+	d.warn("w")
+	refs, atoms := d.refs[:cap(d.refs)], d.atoms[:cap(d.atoms)]
+	PutDecoder(d)
+	expect(Decoder{atoms: atoms[:0], refs: refs[:0]}, *d)
+	expect(make([]any, len(refs)), refs)
+	expect(make([]string, len(atoms)), atoms)
+}
+
+func TestDecoderReset(t *testing.T) {
+	blob := tryWriteValue([]any{map[string]any{"k": "v"}, []any{"x"}})
+	d := NewDecoder(bytes.NewReader(blob))
+	d.CollectWarnings = true
+	_, err := d.Decode()
+	expect(nil, err)
+	expect(true, len(d.refs) > 0)
+	refs, atoms := d.refs[:cap(d.refs)], d.atoms[:cap(d.atoms)]
+	d.Reset(bytes.NewReader(blob))
+	expect(Decoder{r: d.r, atoms: atoms[:0], refs: refs[:0]}, *d)
+	for _, v := range refs {
+		expect(nil, v)
+	}
+	for _, s := range atoms {
+		expect("", s)
+	}
+	// lazily decoded values keep their atoms
+	d.Lazy = true
+	v, err := d.Decode()
+	expect(nil, err)
+	d.Reset(bytes.NewReader(blob))
+	obj, err := v.([]any)[0].(RawValue).Decode()
+	expect(nil, err)
+	expect(map[string]any{"k": "v"}, obj)
+	// even when Lazy is turned off before the decoder is reused
+	d.Lazy = true
+	v, err = d.Decode()
+	expect(nil, err)
+	d.Lazy = false
+	d.Reset(bytes.NewReader(tryWriteValue(map[string]any{"x": 1})))
+	_, err = d.Decode()
+	expect(nil, err)
+	obj, err = v.([]any)[0].(RawValue).Decode()
+	expect(nil, err)
+	expect(map[string]any{"k": "v"}, obj)
+}
+
+func TestDecodeHooks(t *testing.T) {
+	blob := tryWriteValue(map[string]any{"k": "v"})
+	calls := []string{}
+	d := NewDecoder(bytes.NewReader(blob))
+	d.Hooks = DecodeHooks{
+		OnStart: func() { calls = append(calls, "start") },
+		OnValue: func(v any, n int64) {
+			expect(map[string]any{"k": "v"}, v)
+			expect(int64(len(blob)-4), n) // header is version, count, "k"
+			calls = append(calls, "value")
+		},
+		OnEnd: func(n int64, elapsed time.Duration, err error) {
+			expect(int64(len(blob)), n)
+			expect(true, elapsed >= 0)
+			expect(nil, err)
+			calls = append(calls, "end")
+		},
+	}
+	_, err := d.Decode()
+	expect(nil, err)
+	expect([]string{"start", "value", "end"}, calls)
+	// truncated input: OnValue isn't called, OnEnd gets the error
+	calls = nil
+	d.Hooks.OnEnd = func(n int64, elapsed time.Duration, err error) {
+		expect(int64(len(blob)-1), n)
+		expect(io.ErrUnexpectedEOF, err)
+		calls = append(calls, "end")
+	}
+	d.r = bytes.NewReader(blob[:len(blob)-1])
+	_, err = d.Decode()
+	expect(io.ErrUnexpectedEOF, err)
+	expect([]string{"start", "end"}, calls)
+}
+
+func TestBytesRead(t *testing.T) {
+	a := tryWriteValue(map[string]any{"k": "v"})
+	b := tryWriteValue(int32(1))
+	d := NewDecoder(bytes.NewReader(append(a, b...)))
+	expect(int64(0), d.BytesRead())
+	_, err := d.Decode()
+	expect(nil, err)
+	expect(int64(len(a)), d.BytesRead())
+	_, err = d.Decode()
+	expect(nil, err)
+	expect(int64(len(b)), d.BytesRead())
+	_, err = d.Decode()
+	expect(io.EOF, err)
+	expect(int64(0), d.BytesRead())
+	d = NewDecoder(bytes.NewReader(a))
+	expect(nil, d.DecodeObject(&struct{}{}))
+	expect(int64(len(a)), d.BytesRead())
+}
+
 func TestReadObject(t *testing.T) {
 	type empty struct{}
 	expect(&empty{}, tryReadObject(&empty{}, []byte{bcVersion, 0, 8, 0}))
@@ -53,6 +723,380 @@ func TestReadObject(t *testing.T) {
 	expect(&struct{ k *int }{}, tryReadObject(&struct{ k *int }{&k}, []byte{bcVersion, 1, 2, 107, 8, 1, 2, 1}))
 }
 
+func TestReadObjectFieldNames(t *testing.T) {
+	type s struct {
+		Count  int
+		Name   string `js:"name"`
+		Ignore string `js:"-"`
+		count  int
+	}
+	blob := tryWriteValue(map[string]any{"count": int32(1), "name": "x", "Ignore": "y"})
+	// "count" matches the unexported field exactly
+	expect(&s{Name: "x", count: 1}, tryReadObject(&s{}, blob))
+	d := NewDecoder(bytes.NewReader(blob))
+	d.CaseInsensitive = true
+	v := &s{}
+	expect(nil, d.DecodeObject(v))
+	expect(&s{Name: "x", count: 1}, v)
+	// the empty property name doesn't match js:"-" fields
+	blob = tryWriteValue(map[string]any{"": "pwned"})
+	expect(&s{}, tryReadObject(&s{}, blob))
+	d = NewDecoder(bytes.NewReader(blob))
+	d.CaseInsensitive = true
+	v = &s{}
+	expect(nil, d.DecodeObject(v))
+	expect(&s{}, v)
+	type u struct {
+		Count int
+		Name  string
+	}
+	blob = tryWriteValue(map[string]any{"count": int32(1), "NAME": "x"})
+	expect(&u{}, tryReadObject(&u{}, blob))
+	d = NewDecoder(bytes.NewReader(blob))
+	d.CaseInsensitive = true
+	w := &u{}
+	expect(nil, d.DecodeObject(w))
+	expect(&u{1, "x"}, w)
+}
+
+func TestReadObjectNumbers(t *testing.T) {
+	type s struct {
+		i int
+		u uint8
+		f float32
+	}
+	// {i: 3.0, u: 42, f: 42}
+	blob := []byte{bcVersion, 3, 2, 105, 2, 117, 2, 102, 8, 3,
+		2, 6, 0, 0, 0, 0, 0, 0, 8, 64, 4, 5, 84, 6, 5, 84}
+	expect(&s{3, 42, 42}, tryReadObject(&s{}, blob))
+	fail := func(f float64) string {
+		blob := []byte{bcVersion, 1, 2, 105, 8, 1, 2, 6, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(blob[len(blob)-8:], math.Float64bits(f))
+		return ReadObject(bytes.NewReader(blob), &s{}).Error()
+	}
+	expect("serde.ReadObject: cannot store Infinity in int field", fail(math.Inf(1)))
+	expect("serde.ReadObject: cannot store -Infinity in int field", fail(math.Inf(-1)))
+	expect("serde.ReadObject: cannot store NaN in int field", fail(math.NaN()))
+	expect("serde.ReadObject: cannot store 1.5 in int field", fail(1.5))
+	// {u: -1}
+	err := ReadObject(bytes.NewReader([]byte{bcVersion, 1, 2, 117, 8, 1, 2, 5, 1}), &s{})
+	expect("serde.ReadObject: cannot store -1 in uint8 field", err.Error())
+	// unsigned values >= 2^63 don't go through int64
+	type u64 uint64
+	type u struct {
+		U  []u64
+		U8 []uint8
+		I  []int64
+	}
+	have := tryReadObject(&u{}, tryWriteValue(map[string]any{"U": []uint64{1 << 63, math.MaxUint64}}))
+	expect(&u{U: []u64{1 << 63, math.MaxUint64}}, have)
+	err = ReadObject(bytes.NewReader(tryWriteValue(map[string]any{"U8": []uint64{256}})), &u{})
+	expect("serde.ReadObject: cannot store 256 in uint8 field", err.Error())
+	err = ReadObject(bytes.NewReader(tryWriteValue(map[string]any{"I": []uint64{1 << 63}})), &u{})
+	expect("serde.ReadObject: cannot store 9223372036854775808 in int64 field", err.Error())
+}
+
+func TestPatchObject(t *testing.T) {
+	type Inner struct {
+		A, B int
+	}
+	type s struct {
+		Name  string
+		Count int
+		Tags  []string
+		Inner Inner
+		Note  *string
+	}
+	note := "n"
+	v := s{"x", 1, []string{"t"}, Inner{2, 3}, &note}
+	blob := tryWriteValue(map[string]any{
+		"Count": int32(5),
+		"Tags":  Undefined,
+		"Inner": map[string]any{"B": int32(4), "A": Undefined},
+		"Note":  nil,
+	})
+	expect(nil, PatchObject(bytes.NewReader(blob), &v))
+	expect(s{"x", 5, []string{"t"}, Inner{2, 4}, nil}, v)
+	// ReadObject doesn't skip undefined
+	err := ReadObject(bytes.NewReader(blob), &s{})
+	expect("serde.ReadObject: cannot store serde.UndefinedValue in int field", err.Error())
+	err = PatchObject(bytes.NewReader(tryWriteValue(map[string]any{"Count": "x"})), &v)
+	expect("serde.PatchObject: cannot store string in int field", err.Error())
+}
+
+func TestReadObjectPresence(t *testing.T) {
+	type s struct {
+		A     int
+		B     string `js:"b"`
+		C     *int
+		D     bool
+		Inner struct{ X, Y int }
+	}
+	blob := tryWriteValue(map[string]any{"A": int32(0), "b": "x", "C": nil, "Inner": map[string]any{"X": int32(1)}, "extra": true})
+	v := s{D: true}
+	present, err := ReadObjectPresence(bytes.NewReader(blob), &v)
+	expect(nil, err)
+	expect(map[string]bool{"A": true, "b": true, "C": true, "Inner": true}, present)
+	expect(s{B: "x", D: true, Inner: struct{ X, Y int }{1, 0}}, v)
+	present, err = ReadObjectPresence(bytes.NewReader(tryWriteValue(map[string]any{})), &v)
+	expect(nil, err)
+	expect(map[string]bool{}, present)
+	present, err = ReadObjectPresence(bytes.NewReader(tryWriteValue(map[string]any{"A": "x"})), &v)
+	expect("serde.ReadObject: cannot store string in int field", err.Error())
+	expect(map[string]bool(nil), present)
+}
+
+func TestReadObjectNamedNumbers(t *testing.T) {
+	type Celsius float64
+	type level uint8
+	type s struct {
+		Timeout time.Duration
+		Temp    Celsius
+		Level   level
+	}
+	blob := tryWriteValue(map[string]any{"Timeout": int32(1500), "Temp": 21.5, "Level": int32(3)})
+	expect(&s{1500, 21.5, 3}, tryReadObject(&s{}, blob))
+	v := s{2 * time.Second, -40, 7}
+	expect(&v, tryReadObject(&s{}, tryWriteValue(v)))
+	expect(map[string]any{"Timeout": int32(2e9), "Temp": float64(-40), "Level": int32(7)}, tryReadValue(tryWriteValue(v)))
+}
+
+func TestReadObjectSlices(t *testing.T) {
+	type s struct {
+		Tags    []string
+		Counts  []int
+		Samples []float64
+	}
+	blob := tryWriteValue(map[string]any{
+		"Tags":    []any{"a", "b"},
+		"Counts":  []int16{1, 2},
+		"Samples": []any{int32(1), 2.5},
+	})
+	expect(&s{[]string{"a", "b"}, []int{1, 2}, []float64{1, 2.5}}, tryReadObject(&s{}, blob))
+	blob = tryWriteValue(map[string]any{"Tags": []any{"a", int32(1)}})
+	err := ReadObject(bytes.NewReader(blob), &s{})
+	expect("serde.ReadObject: cannot store int32 in string field", err.Error())
+}
+
+func TestReadObjectMaps(t *testing.T) {
+	type player string
+	type s struct {
+		Scores map[string]int
+		Names  map[player]string
+		Any    map[string]any
+	}
+	blob := tryWriteValue(map[string]any{
+		"Scores": map[string]any{"a": int32(1), "b": 2.0},
+		"Names":  map[string]any{"p": "x"},
+		"Any":    map[string]any{"k": []any{}},
+	})
+	want := &s{map[string]int{"a": 1, "b": 2}, map[player]string{"p": "x"}, map[string]any{"k": []any{}}}
+	expect(want, tryReadObject(&s{}, blob))
+	ordered := &s{}
+	d := NewDecoder(bytes.NewReader(blob))
+	d.OrderedObjects = true
+	expect(nil, d.DecodeObject(ordered))
+	expect(want.Scores, ordered.Scores)
+	blob = tryWriteValue(map[string]any{"Scores": map[string]any{"a": 1.5}})
+	err := ReadObject(bytes.NewReader(blob), &s{})
+	expect("serde.ReadObject: cannot store 1.5 in int field", err.Error())
+}
+
+type state string
+
+func TestReadObjectEnums(t *testing.T) {
+	type T struct {
+		State   state
+		History []state
+		Name    string
+	}
+	decode := func(v any) (*T, error) {
+		d := NewDecoder(bytes.NewReader(tryWriteValue(v)))
+		d.Enums = map[reflect.Type][]string{
+			reflect.TypeOf(state("")): {"idle", "running"},
+		}
+		have := &T{}
+		err := d.DecodeObject(have)
+		return have, err
+	}
+	have, err := decode(map[string]any{"State": "running", "History": []any{"idle"}, "Name": "x"})
+	expect(nil, err)
+	expect(&T{State: "running", History: []state{"idle"}, Name: "x"}, have)
+	_, err = decode(map[string]any{"State": "stopped"})
+	expect(`serde.ReadObject: "stopped" is not a valid serde.state`, err.Error())
+	_, err = decode(map[string]any{"History": []any{"idle", "Running"}})
+	expect(`serde.ReadObject: "Running" is not a valid serde.state`, err.Error())
+	// without Enums, any string goes
+	have = &T{}
+	expect(nil, ReadObject(bytes.NewReader(tryWriteValue(map[string]any{"State": "stopped"})), have))
+	expect(&T{State: "stopped"}, have)
+}
+
+func TestReadObjectNilPointers(t *testing.T) {
+	type Section struct {
+		Host string
+		Port int
+		TLS  *struct{ Cert string }
+	}
+	type config struct {
+		Name    string
+		DB      *Section
+		Cache   *Section
+		Missing *Section
+	}
+	blob := tryWriteValue(map[string]any{
+		"Name":  "x",
+		"DB":    map[string]any{"Host": "db", "Port": int32(5432), "TLS": map[string]any{"Cert": "c"}},
+		"Cache": nil,
+	})
+	have := tryReadObject(&config{Cache: &Section{}}, blob).(*config)
+	expect(&Section{"db", 5432, &struct{ Cert string }{"c"}}, have.DB)
+	expect((*Section)(nil), have.Cache)
+	expect((*Section)(nil), have.Missing)
+	// existing sections are updated, not replaced
+	db := &Section{Host: "old", Port: 1}
+	blob = tryWriteValue(map[string]any{"DB": map[string]any{"Host": "new"}})
+	have = tryReadObject(&config{DB: db}, blob).(*config)
+	expect(true, have.DB == db)
+	expect(&Section{Host: "new", Port: 1}, db)
+	blob = tryWriteValue(map[string]any{"DB": "s"})
+	err := ReadObject(bytes.NewReader(blob), &config{})
+	expect("serde.ReadObject: cannot store string in *serde.Section field", err.Error())
+	// sections that fail to decode aren't allocated
+	blob = tryWriteValue(map[string]any{"DB": map[string]any{"Port": "x"}})
+	have = &config{}
+	err = ReadObject(bytes.NewReader(blob), have)
+	expect("serde.ReadObject: cannot store string in int field", err.Error())
+	expect((*Section)(nil), have.DB)
+}
+
+func TestCollectWarnings(t *testing.T) {
+	type s struct {
+		N int
+		F float64
+		S string
+		M map[string]int
+	}
+	blob := tryWriteValue(map[string]any{"N": 2.0, "F": int32(1), "S": int32(3), "M": map[string]any{"a": 1.5}})
+	err := ReadObject(bytes.NewReader(blob), &s{})
+	expect(true, err != nil)
+	d := NewDecoder(bytes.NewReader(blob))
+	d.CollectWarnings = true
+	have := &s{S: "x"}
+	expect(nil, d.DecodeObject(have))
+	expect(&s{N: 2, F: 1, S: "x"}, have)
+	// properties are sorted by the writer: F, M, N, S
+	expect([]Warning{
+		{29, "cannot store 1.5 in int field"},
+		{39, "converted float64 2 to int"},
+		{42, "cannot store int32 in string field"},
+	}, d.Warnings())
+	expect("converted float64 2 to int at offset 39", d.Warnings()[1].String())
+	// {a: 1, a: 2}
+	blob = []byte{bcVersion, 1, 2, 'a', tagObject, 2, 2, tagInt32, 2, 2, tagInt32, 4}
+	d = NewDecoder(bytes.NewReader(blob))
+	d.CollectWarnings = true
+	v, err := d.Decode()
+	expect(nil, err)
+	expect(map[string]any{"a": int32(2)}, v)
+	expect([]Warning{{11, `duplicate property "a"`}}, d.Warnings())
+	// reset by the next call
+	d = NewDecoder(bytes.NewReader(append(blob, tryWriteValue(nil)...)))
+	d.CollectWarnings = true
+	_, err = d.Decode()
+	expect(nil, err)
+	expect(1, len(d.Warnings()))
+	_, err = d.Decode()
+	expect(nil, err)
+	expect(0, len(d.Warnings()))
+}
+
+func TestMaxElements(t *testing.T) {
+	decode := func(max int, v any) error {
+		d := NewDecoder(bytes.NewReader(tryWriteValue(v)))
+		d.MaxElements = max
+		_, err := d.Decode()
+		return err
+	}
+	objects := []any{}
+	for i := 0; i < 10; i++ {
+		objects = append(objects, map[string]any{"id": int32(i)})
+	}
+	expect(nil, decode(0, objects))
+	expect(nil, decode(20, objects)) // 10 elements, 10 properties
+	err := decode(19, objects)
+	expect("serde.ReadValue: more than 19 array elements and object properties", err.Error())
+	err = decode(5, objects)
+	expect("serde.ReadValue: more than 5 array elements and object properties", err.Error())
+	// typed arrays have elements too but aren't materialized one by one
+	expect(nil, decode(1, []float64{1, 2, 3}))
+	d := NewDecoder(bytes.NewReader(tryWriteValue(map[string]any{"A": int32(1), "B": int32(2)})))
+	d.MaxElements = 1
+	err = d.DecodeObject(&struct{ A, B int }{})
+	expect("serde.ReadObject: more than 1 array elements and object properties", err.Error())
+}
+
+func TestMaxTypedArrayBytes(t *testing.T) {
+	v := map[string]any{
+		"a": make([]float64, 100),
+		"b": make([]int32, 100),
+		"c": ArrayBuffer{make([]byte, 100)},
+		"d": []any{make([]uint16, 50)},
+	}
+	blob := tryWriteValue(v)
+	d := NewDecoder(bytes.NewReader(blob))
+	d.MaxTypedArrayBytes = 1400 // 800 + 400 + 100 + 100
+	_, err := d.Decode()
+	expect(nil, err)
+	// the count starts over for each blob
+	d = NewDecoder(bytes.NewReader(bytes.Repeat(blob, 2)))
+	d.MaxTypedArrayBytes = 1400
+	_, err = d.Decode()
+	expect(nil, err)
+	_, err = d.Decode()
+	expect(nil, err)
+	d = NewDecoder(bytes.NewReader(blob))
+	d.MaxTypedArrayBytes = 1399
+	_, err = d.Decode()
+	expect("serde.ReadValue: more than 1399 bytes of typed arrays and arraybuffers", err.Error())
+	// a huge length is rejected before allocating
+	huge := []byte{bcVersion, 0, tagTypedArray, uint8Array, 0xff, 0xff, 0xff, 0xff, 0x0f, 0, tagArrayBuffer, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	d = NewDecoder(bytes.NewReader(huge))
+	d.MaxTypedArrayBytes = 1 << 20
+	_, err = d.Decode()
+	expect("serde.ReadValue: more than 1048576 bytes of typed arrays and arraybuffers", err.Error())
+}
+
+func TestAllowedTags(t *testing.T) {
+	decode := func(v any, lazy bool, tags ...string) (any, error) {
+		d := NewDecoder(bytes.NewReader(tryWriteValue(v)))
+		d.AllowedTags = tags
+		d.Lazy = lazy
+		return d.Decode()
+	}
+	plain := []string{"object", "array", "string", "int32", "float64"}
+	v := map[string]any{"a": []any{"s", int32(1), 1.5}}
+	have, err := decode(v, false, plain...)
+	expect(nil, err)
+	expect(v, have)
+	_, err = decode(map[string]any{"a": []any{"s", []float64{1}}}, false, plain...)
+	expect("serde.ReadValue: typed array not allowed at offset 12", err.Error())
+	_, err = decode(map[string]any{"a": []any{"s", []float64{1}}}, true, plain...)
+	expect("serde.ReadValue: typed array not allowed at offset 12", err.Error())
+	_, err = decode(map[string]any{"a": true}, false, plain...)
+	expect("serde.ReadValue: true not allowed at offset 7", err.Error())
+	_, err = decode(nil, false, plain...)
+	expect("serde.ReadValue: null not allowed at offset 2", err.Error())
+	_, err = decode(time.Unix(0, 0), false, "date", "float64")
+	expect(nil, err)
+	_, err = decode(time.Unix(0, 0), false, "date")
+	expect("serde.ReadValue: float64 not allowed at offset 3", err.Error())
+	_, err = decode(nil, false, "null", "typedarray")
+	expect(`serde.ReadValue: unknown tag name "typedarray"`, err.Error())
+	_, err = decode(nil, false, []string{}...)
+	expect("serde.ReadValue: null not allowed at offset 2", err.Error())
+}
+
 func TestWriteValue(t *testing.T) {
 	expect([]byte{bcVersion, 0, tagNull}, tryWriteValue(nil))
 	expect([]byte{bcVersion, 0, tagUndefined}, tryWriteValue(Undefined))
@@ -62,6 +1106,941 @@ func TestWriteValue(t *testing.T) {
 	expect([]byte{bcVersion, 0, 15, 1, 42}, tryWriteValue(ArrayBuffer{[]byte{42}}))
 	expect([]byte{bcVersion, 0, 14, 0, 0, 0, 15, 0}, tryWriteValue(Uint8ClampedArray{}))
 	expect([]byte{bcVersion, 0, 14, 2, 1, 0, 15, 1, 42}, tryWriteValue([]byte{42}))
+	expect([]byte{bcVersion, 0, 7, 4, 111, 107}, tryWriteValue("ok"))
+	expect([]byte{bcVersion, 0, 7, 5, 61, 216, 45, 222}, tryWriteValue("😭"))
+	expect([]byte{bcVersion, 1, 2, 107, 8, 1, 2, 1}, tryWriteValue(map[string]any{"k": nil}))
+	expect([]byte{bcVersion, 0, 8, 1, 85, 1}, tryWriteValue(map[string]any{"42": nil}))
+	expect([]byte{bcVersion, 1, 6, 48, 52, 50, 8, 1, 2, 1}, tryWriteValue(map[string]any{"042": nil}))
+}
+
+func TestEmptyTypedArrays(t *testing.T) {
+	for _, v := range []any{
+		Uint8ClampedArray{[]byte{}},
+		[]int8{},
+		[]uint8{},
+		[]int16{},
+		[]uint16{},
+		[]int32{},
+		[]uint32{},
+		[]int64{},
+		[]uint64{},
+		[]float32{},
+		[]float64{},
+	} {
+		blob := tryWriteValue(v)
+		have := tryReadValue(blob)
+		expect(v, have)
+		if ua, ok := have.(Uint8ClampedArray); ok {
+			have = ua.Bytes
+		}
+		expect(false, reflect.ValueOf(have).IsNil())
+		// nil slices are written as empty typed arrays
+		expect(blob, tryWriteValue(reflect.Zero(reflect.TypeOf(v)).Interface()))
+	}
+	expect([]float64{}, tryReadValue([]byte{bcVersion, 0, 14, 10, 0, 0, 15, 0}))
+}
+
+func TestNarrowFloats(t *testing.T) {
+	narrow := func(d *Decoder) { d.NarrowFloats = true }
+	blob := tryWriteValue([]any{3.0, 3.5, math.Copysign(0, -1), 1e10, float64(math.MinInt32)})
+	expect([]any{int32(3), 3.5, math.Copysign(0, -1), 1e10, int32(math.MinInt32)}, tryDecode(blob, narrow))
+	expect(3.0, tryReadValue(tryWriteValue(3.0)))
+	v := tryDecode(tryWriteValue(3.0), func(d *Decoder) { d.NarrowFloats, d.UseNumber = true, true })
+	expect(Number("3"), v)
+}
+
+func TestBigInt(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-18446744073709551616", 10) // -2**64
+	for _, c := range []struct {
+		v    *big.Int
+		blob []byte
+	}{
+		{big.NewInt(0), []byte{tagBigInt, 0}},
+		{big.NewInt(1), []byte{tagBigInt, 1, 1}},
+		{big.NewInt(-1), []byte{tagBigInt, 1, 0xff}},
+		{big.NewInt(127), []byte{tagBigInt, 1, 0x7f}},
+		{big.NewInt(128), []byte{tagBigInt, 2, 0x80, 0}},
+		{big.NewInt(-128), []byte{tagBigInt, 1, 0x80}},
+		{big.NewInt(-129), []byte{tagBigInt, 2, 0x7f, 0xff}},
+		{huge, []byte{tagBigInt, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0xff}},
+	} {
+		blob := append([]byte{bcVersion, 0}, c.blob...)
+		expect(blob, tryWriteValue(c.v))
+		expect(c.v, tryReadValue(blob))
+		canon, err := Canonicalize(blob)
+		expect(nil, err)
+		expect(blob, canon)
+	}
+	// non-minimal encodings are accepted
+	expect(big.NewInt(-1), tryReadValue([]byte{bcVersion, 0, tagBigInt, 2, 0xff, 0xff}))
+}
+
+func TestBigIntAsInt64(t *testing.T) {
+	small := tryWriteValue(big.NewInt(-42))
+	huge := tryWriteValue(new(big.Int).Lsh(big.NewInt(1), 63))
+	decode := func(blob []byte) any {
+		return tryDecode(blob, func(d *Decoder) { d.BigIntAsInt64 = true })
+	}
+	expect(int64(-42), decode(small))
+	expect(new(big.Int).Lsh(big.NewInt(1), 63), decode(huge))
+	expect(big.NewInt(-42), tryReadValue(small))
+}
+
+func TestBigIntArrays(t *testing.T) {
+	decode := func(blob []byte) any {
+		return tryDecode(blob, func(d *Decoder) { d.BigIntArrays = true })
+	}
+	huge := new(big.Int).SetUint64(math.MaxUint64)
+	expect([]*big.Int{big.NewInt(0), big.NewInt(1 << 62), new(big.Int).Lsh(big.NewInt(1), 63), huge},
+		decode(tryWriteValue([]uint64{0, 1 << 62, 1 << 63, math.MaxUint64})))
+	expect([]*big.Int{big.NewInt(math.MinInt64), big.NewInt(-1), big.NewInt(math.MaxInt64)},
+		decode(tryWriteValue([]int64{math.MinInt64, -1, math.MaxInt64})))
+	expect([]*big.Int{}, decode(tryWriteValue([]int64{})))
+	expect([]uint64{math.MaxUint64}, tryReadValue(tryWriteValue([]uint64{math.MaxUint64})))
+	expect([]int32{1}, decode(tryWriteValue([]int32{1})))
+}
+
+func TestBigIntEncodings(t *testing.T) {
+	// -5n in the minimal form and padded to a 64 bits limb
+	short := []byte{bcVersion, 0, tagBigInt, 1, 0xfb}
+	long := []byte{bcVersion, 0, tagBigInt, 8, 0xfb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	// 2n**64n-1n padded to two limbs
+	wide := []byte{bcVersion, 0, tagBigInt, 16, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+	max := new(big.Int).SetUint64(math.MaxUint64)
+	asInt64 := func(d *Decoder) { d.BigIntAsInt64 = true }
+	for _, blob := range [][]byte{short, long} {
+		expect(big.NewInt(-5), tryReadValue(blob))
+		expect(int64(-5), tryDecode(blob, asInt64))
+	}
+	expect(max, tryReadValue(wide))
+	expect(max, tryDecode(wide, asInt64))
+	expect(int64(0), tryDecode([]byte{bcVersion, 0, tagBigInt, 0}, asInt64))
+	expect(int64(math.MinInt64), tryDecode(tryWriteValue(big.NewInt(math.MinInt64)), asInt64))
+	expect(int64(math.MaxInt64), tryDecode(tryWriteValue(big.NewInt(math.MaxInt64)), asInt64))
+	nine := []byte{bcVersion, 0, tagBigInt, 9, 0xfb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	expect(int64(-5), tryDecode(nine, asInt64))
+}
+
+func TestNestedTypedArrays(t *testing.T) {
+	v := map[string]any{"a": []float64{1.5}, "b": int32(1)}
+	blob := []byte{
+		bcVersion, 2, 2, 'a', 2, 'b',
+		tagObject, 2,
+		2, tagTypedArray, float64Array, 1, 0, tagArrayBuffer, 8, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+		4, tagInt32, 2,
+	}
+	expect(blob, tryWriteValue(v))
+	expect(v, tryReadValue(blob))
+	w := []any{[]int16{1}, map[string]any{"c": []uint8{2, 3}}, []int16{}}
+	expect(w, tryReadValue(tryWriteValue(w)))
+}
+
+func TestSharedArrayBufferViews(t *testing.T) {
+	// [u8, new Int16Array(u8.buffer, 2, 1), u8.buffer] where u8 is
+	// new Uint8Array([1, 2, 3, 4])
+	blob := []byte{
+		bcVersion, 0, tagArray, 3,
+		tagTypedArray, uint8Array, 4, 0, tagArrayBuffer, 4, 1, 2, 3, 4,
+		tagTypedArray, int16Array, 1, 2, tagObjectReference, 2,
+		tagObjectReference, 2,
+	}
+	v := tryReadValue(blob).([]any)
+	u8, i16, buf := v[0].([]byte), v[1].([]int16), v[2].([]byte)
+	expect([]byte{1, 2, 3, 4}, u8)
+	expect([]int16{0x0403}, i16)
+	expect([]byte{1, 2, 3, 4}, buf)
+	u8[3] = 0xff
+	expect(byte(0xff), buf[3])
+	if hostLittleEndian {
+		expect(int16(-253), i16[0]) // 0xff03
+	}
+	v = tryDecode(blob, func(d *Decoder) { d.WrapArrayBuffers = true }).([]any)
+	expect(ArrayBuffer{[]byte{1, 2, 3, 4}}, v[2])
+	// views are bounded by their arraybuffer
+	_, err := ReadValue(bytes.NewReader([]byte{bcVersion, 0, tagTypedArray, int16Array, 2, 1, tagArrayBuffer, 4, 1, 2, 3, 4}))
+	expect("serde.ReadValue: Int16Array of 2 elements (4 bytes) at offset 1 exceeds arraybuffer of 4 bytes", err.Error())
+	// and have no holes: new Float64Array(2) with a buffer that is 1 byte short
+	blob = []byte{bcVersion, 0, tagTypedArray, float64Array, 2, 0, tagArrayBuffer, 15}
+	blob = append(blob, make([]byte, 15)...)
+	_, err = ReadValue(bytes.NewReader(blob))
+	expect("serde.ReadValue: Float64Array of 2 elements (16 bytes) at offset 0 exceeds arraybuffer of 15 bytes", err.Error())
+	err = Validate(bytes.NewReader(blob))
+	expect("serde.Validate: Float64Array of 2 elements (16 bytes) at offset 0 exceeds arraybuffer of 15 bytes", err.Error())
+	// also when the arraybuffer is shared: u8 = new Uint8Array(4); [u8, new Uint32Array(u8.buffer, 0, 2)]
+	blob = []byte{bcVersion, 0, tagArray, 2, tagTypedArray, uint8Array, 4, 0, tagArrayBuffer, 4, 0, 0, 0, 0,
+		tagTypedArray, uint32Array, 2, 0, tagObjectReference, 2}
+	_, err = ReadValue(bytes.NewReader(blob))
+	expect("serde.ReadValue: Uint32Array of 2 elements (8 bytes) at offset 0 exceeds arraybuffer of 4 bytes", err.Error())
+	_, err = ReadValue(bytes.NewReader([]byte{bcVersion, 0, tagArray, 1, tagTypedArray, uint8Array, 0, 0, tagObjectReference, 0}))
+	expect("serde.ReadValue: typed array refers to non-arraybuffer object: 0", err.Error())
+}
+
+func TestSharedArrayBuffer(t *testing.T) {
+	// new SharedArrayBuffer(8, {maxByteLength: 64})
+	growable := []byte{
+		bcVersion, 0, tagSharedArrayBuffer, 8, 64,
+		0x10, 0x32, 0x54, 0x76, 0x98, 0xba, 0xdc, 0xfe,
+	}
+	v := SharedArrayBuffer{ByteLength: 8, MaxByteLength: 64, Addr: 0xfedcba9876543210}
+	expect(v, tryReadValue(growable))
+	expect(growable, tryWriteValue(v))
+	// new SharedArrayBuffer(8)
+	fixed := []byte{
+		bcVersion, 0, tagSharedArrayBuffer, 8, 0xff, 0xff, 0xff, 0xff, 0x0f,
+		0x10, 0x32, 0x54, 0x76, 0x98, 0xba, 0xdc, 0xfe,
+	}
+	v.MaxByteLength = -1
+	expect(v, tryReadValue(fixed))
+	expect(fixed, tryWriteValue(v))
+	_, err := ReadValue(bytes.NewReader(growable[:10]))
+	expect(io.ErrUnexpectedEOF, err)
+	growable[4] = 4
+	_, err = ReadValue(bytes.NewReader(growable))
+	expect("serde.ReadValue: sharedarraybuffer of 8 bytes has smaller maximum length 4", err.Error())
+}
+
+func TestRegExp(t *testing.T) {
+	// /x/gimsuy; the bytecode is the 8 bytes header and a made-up body
+	blob := []byte{
+		bcVersion, 0, tagRegExp, 2, 'x',
+		2 * 10, 0x3f, 0, 1, 2, 2, 0, 0, 0, 0xaa, 0xbb,
+	}
+	re := tryReadValue(blob).(RegExp)
+	expect(RegExp{Source: "x", Global: true, IgnoreCase: true, Multiline: true, DotAll: true, Unicode: true, Sticky: true}, re)
+	expect("/x/gimsuy", re.String())
+	blob[6] = lreFlagIndices | lreFlagNamedGroups
+	blob[7] = lreFlagUnicodeSets >> 8
+	expect("/x/dv", tryReadValue(blob).(RegExp).String())
+	expect(nil, Validate(bytes.NewReader(blob)))
+	// [re, re]
+	v := tryReadValue(append([]byte{bcVersion, 0, tagArray, 2}, append(blob[2:], tagObjectReference, 1)...))
+	expect([]any{RegExp{Source: "x", HasIndices: true, UnicodeSets: true}, RegExp{Source: "x", HasIndices: true, UnicodeSets: true}}, v)
+	err := WriteValue(io.Discard, re)
+	expect(true, errors.Is(err, ErrUnsupportedType))
+	_, err = ReadValue(bytes.NewReader([]byte{bcVersion, 0, tagRegExp, 2, 'x', 2, 0x3f}))
+	expect("serde.ReadValue: regexp bytecode too short", err.Error())
+}
+
+func TestStreamBuffers(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1e5)
+	blob := tryWriteValue(ArrayBuffer{data})
+	next := tryWriteValue("next")
+	d := NewDecoder(bytes.NewReader(append(blob, next...)))
+	d.StreamBuffers = true
+	v, err := d.Decode()
+	expect(nil, err)
+	ab := v.(*ArrayBufferReader)
+	expect(len(data), ab.ByteLength)
+	expect(int64(len(blob)-len(data)), d.BytesRead())
+	sink := bytes.Buffer{}
+	n, err := io.Copy(&sink, ab)
+	expect(nil, err)
+	expect(int64(len(data)), n)
+	expect(data, sink.Bytes())
+	v, err = d.Decode()
+	expect(nil, err)
+	expect("next", v)
+	// nested arraybuffers aren't streamed
+	v = tryDecode(tryWriteValue([]any{ArrayBuffer{[]byte{1}}}), func(d *Decoder) { d.StreamBuffers = true })
+	expect([]any{[]byte{1}}, v)
+	// truncated
+	d = NewDecoder(bytes.NewReader(blob[:len(blob)-1]))
+	d.StreamBuffers = true
+	v, err = d.Decode()
+	expect(nil, err)
+	_, err = io.Copy(io.Discard, v.(*ArrayBufferReader))
+	expect(io.ErrUnexpectedEOF, err)
+}
+
+func TestBufferFrom(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1e4)
+	name := filepath.Join(t.TempDir(), "data")
+	expect(nil, os.WriteFile(name, data, 0o600))
+	f, err := os.Open(name)
+	expect(nil, err)
+	defer f.Close()
+	v := map[string]any{"a": "x", "b": BufferFrom(f, len(data)), "c": []any{int32(1)}}
+	blob := tryWriteValue(v)
+	v["b"] = ArrayBuffer{data}
+	expect(tryWriteValue(v), blob)
+	n, err := WriteValueCounting(BufferFrom(bytes.NewReader(data), 10))
+	expect(nil, err)
+	expect(len(tryWriteValue(ArrayBuffer{data[:10]})), n)
+	err = WriteValue(io.Discard, []any{BufferFrom(bytes.NewReader(data[:5]), 10)})
+	expect("serde.WriteValue: BufferFrom reader ended after 5 of 10 bytes", err.Error())
+	e := NewEncoder(io.Discard)
+	e.MaxOutputBytes = 100
+	err = e.Encode(BufferFrom(bytes.NewReader(data), 100))
+	expect("serde.WriteValue: output exceeds 100 bytes", err.Error())
+}
+
+func TestTypedArrayBufferError(t *testing.T) {
+	// {a: new Uint8Array(1)} with the arraybuffer tag replaced by an object tag
+	blob := []byte{bcVersion, 1, 2, 'a', tagObject, 1, 2, tagTypedArray, uint8Array, 1, 0, tagObject, 1, 0}
+	for _, err := range []error{
+		func() error { _, err := ReadValue(bytes.NewReader(blob)); return err }(),
+		Validate(bytes.NewReader(blob)),
+		func() error { _, err := Dump(blob); return err }(),
+	} {
+		var tae *TypedArrayBufferError
+		expect(true, errors.As(err, &tae))
+		expect(TypedArrayBufferError{tagObject, 11}, *tae)
+		expect("typed array not followed by arraybuffer but by object at offset 11", err.Error())
+	}
+	d := NewDecoder(bytes.NewReader(blob))
+	d.Lazy = true
+	_, err := d.Decode()
+	expect("typed array not followed by arraybuffer but by object at offset 11", err.Error())
+}
+
+func TestByteArrays(t *testing.T) {
+	v := []any{ArrayBuffer{[]byte{1}}, Uint8ClampedArray{[]byte{2}}, []byte{3}}
+	expect([]any{[]byte{1}, Uint8ClampedArray{[]byte{2}}, []byte{3}}, tryReadValue(tryWriteValue(v)))
+	expect(Uint8ClampedArray{[]byte{}}, tryReadValue(tryWriteValue(Uint8ClampedArray{})))
+	pixels := []byte{0, 128, 255, 255}
+	expect(Clamped(pixels), tryReadValue(tryWriteValue(Clamped(pixels))))
+	expect(map[string]any{"data": Clamped(pixels)}, tryReadValue(tryWriteValue(map[string]any{"data": Clamped(pixels)})))
+	expect(pixels, tryReadValue(tryWriteValue(pixels)))
+}
+
+func TestWriteRunes(t *testing.T) {
+	expect("héllo", tryReadValue(tryWriteValue(Runes("héllo"))))
+	expect([]int32{104, 233}, tryReadValue(tryWriteValue([]rune("hé"))))
+	expect(int32('é'), tryReadValue(tryWriteValue('é')))
+}
+
+func TestWriteNamedSlice(t *testing.T) {
+	type Samples []float32
+	type Sample float64
+	type Bytes []byte
+	expect(tryWriteValue([]float32{1.5, 2}), tryWriteValue(Samples{1.5, 2}))
+	expect([]float32{1.5, 2}, tryReadValue(tryWriteValue(Samples{1.5, 2})))
+	expect([]float64{1.5}, tryReadValue(tryWriteValue([]Sample{1.5})))
+	expect([]byte{42}, tryReadValue(tryWriteValue(Bytes{42})))
+}
+
+type writeCounter struct {
+	bytes.Buffer
+	calls int
+}
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	w.calls++
+	return w.Buffer.Write(b)
+}
+
+func TestWriteWithoutAtoms(t *testing.T) {
+	// the body is streamed in chunks, not held in memory, when there are
+	// no atoms
+	a := make([]any, 10000)
+	for i := range a {
+		a[i] = int32(i)
+	}
+	w := writeCounter{}
+	expect(nil, WriteValue(&w, a))
+	expect(true, w.calls > 1 && w.calls < 20)
+	expect(a, tryReadValue(w.Bytes()))
+	w = writeCounter{}
+	expect(nil, WriteValue(&w, []any{"x", []float64{1, 2}, nil, big.NewInt(1)}))
+	expect(1, w.calls)
+	expect([]byte{bcVersion, 0}, w.Bytes()[:2])
+	// which is then partial when the value can't be written
+	w = writeCounter{}
+	err := WriteValue(&w, []any{make([]byte, 10000), BufferFrom(strings.NewReader("x"), 2)})
+	expect("serde.WriteValue: BufferFrom reader ended after 1 of 2 bytes", err.Error())
+	expect(true, w.Len() > 0)
+	// but it is when there are
+	for _, v := range []any{map[string]any{}, []any{a, map[string]any{"k": nil}}} {
+		w = writeCounter{}
+		expect(nil, WriteValue(&w, v))
+		expect(true, w.calls < 10)
+		expect(v, tryReadValue(w.Bytes()))
+	}
+}
+
+func BenchmarkWriteNumericArray(b *testing.B) {
+	a := make([]any, 100000)
+	for i := range a {
+		a[i] = float64(i) / 2
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := WriteValue(io.Discard, a); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFrequentAtomsFirst(t *testing.T) {
+	frequentFirst := func(e *Encoder) { e.FrequentAtomsFirst = true }
+	// 100 keys that are used once come before the keys that every row
+	// has, which then get indices > 63 that take two bytes
+	rows := []any{}
+	for i := 0; i < 100; i++ {
+		rows = append(rows, map[string]any{fmt.Sprintf("rare%d", i): true})
+	}
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, map[string]any{"id": int32(i), "name": "x"})
+	}
+	rows = append(rows, map[string]any{"id": int32(0)})
+	blob := tryEncode(rows, frequentFirst)
+	atoms, err := ReadAtoms(bytes.NewReader(blob))
+	expect(nil, err)
+	expect([]string{"id", "name", "rare0", "rare1"}, atoms[:4])
+	expect(rows, tryReadValue(blob))
+	expect(len(tryWriteValue(rows))-1999, len(blob)) // 13082 vs. 11083 bytes
+	// BufferFrom readers are read once
+	blob = tryEncode(map[string]any{"a": BufferFrom(strings.NewReader("xyz"), 3)}, frequentFirst)
+	expect(map[string]any{"a": []byte("xyz")}, tryReadValue(blob))
+}
+
+func TestZeroCopy(t *testing.T) {
+	zeroCopy := func(e *Encoder) { e.ZeroCopy = true }
+	f := []float64{1, 2.5, -3, math.Inf(1), 5}
+	for _, v := range []any{
+		f[1:4],
+		f[:0],
+		[]int8{-1, 2},
+		[]uint16{0xFFFE},
+		[]int32{-1 << 31},
+		[]uint64{1 << 63},
+		[]float32{0.5},
+		Clamped([]byte{1, 2, 3}[1:]),
+		[]uint8{1},
+		struct{ A []int64 }{[]int64{-2}},
+	} {
+		expect(tryWriteValue(v), tryEncode(v, zeroCopy))
+	}
+	expect([]float64{2.5, -3, math.Inf(1)}, tryReadValue(tryEncode(f[1:4], zeroCopy)))
+}
+
+func BenchmarkWriteFloat64Array(b *testing.B) {
+	a := make([]float64, 200000)
+	for i := range a {
+		a[i] = float64(i) / 2
+	}
+	for _, zeroCopy := range []bool{false, true} {
+		b.Run(fmt.Sprintf("ZeroCopy=%v", zeroCopy), func(b *testing.B) {
+			e := NewEncoder(io.Discard)
+			e.ZeroCopy = zeroCopy
+			b.ReportAllocs()
+			b.SetBytes(int64(8 * len(a[1:])))
+			for i := 0; i < b.N; i++ {
+				if err := e.Encode(a[1:]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteValueCounting(t *testing.T) {
+	for _, v := range []any{
+		nil,
+		"x",
+		map[string]any{"a": []any{int32(1), "\u2603"}, "b": []float64{1, 2}},
+		struct{ N int }{42},
+	} {
+		n, err := WriteValueCounting(v)
+		expect(nil, err)
+		expect(len(tryWriteValue(v)), n)
+	}
+	_, err := WriteValueCounting(make(chan int))
+	expect(true, errors.Is(err, ErrUnsupportedType))
+}
+
+func TestWriteOnUnsupported(t *testing.T) {
+	type point [2]int
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	e.OnUnsupported = func(v any) (any, error) {
+		if p, ok := v.(point); ok {
+			return fmt.Sprintf("(%d,%d)", p[0], p[1]), nil
+		}
+		return nil, fmt.Errorf("cannot convert %T", v)
+	}
+	expect(nil, e.Encode(point{1, 2}))
+	expect("(1,2)", tryReadValue(buf.Bytes()))
+	expect("cannot convert chan int", e.Encode(make(chan int)).Error())
+}
+
+func TestLenientAtoms(t *testing.T) {
+	blob := []byte{bcVersion, 1, 2, 'a', tagObject, 2, 2, tagNull, 6, tagTrue}
+	_, err := ReadValue(bytes.NewReader(blob))
+	expect("serde.ReadValue: atom out of range", err.Error())
+	v := tryDecode(blob, func(d *Decoder) { d.LenientAtoms = true })
+	expect(map[string]any{"a": nil, "<atom 3>": true}, v)
+}
+
+func TestInt32Zigzag(t *testing.T) {
+	expect([]byte{bcVersion, 0, tagInt32, 84}, tryWriteValue(int32(42)))
+	expect([]byte{bcVersion, 0, tagInt32, 83}, tryWriteValue(int32(-42)))
+	expect([]byte{bcVersion, 0, tagInt32, 0xfe, 0xff, 0xff, 0xff, 0x0f}, tryWriteValue(int32(math.MaxInt32)))
+	expect([]byte{bcVersion, 0, tagInt32, 0xff, 0xff, 0xff, 0xff, 0x0f}, tryWriteValue(int32(math.MinInt32)))
+	vs := []int32{math.MinInt32, math.MinInt32 + 1, math.MaxInt32 - 1, math.MaxInt32}
+	for shift := 0; shift < 31; shift++ {
+		n := int32(1) << shift
+		vs = append(vs, n-1, n, -n, -n-1)
+	}
+	for i := 0; i < 1000; i++ {
+		vs = append(vs, int32(rand.Uint32()))
+	}
+	for _, v := range vs {
+		blob := tryWriteValue(v)
+		expect(v, tryReadValue(blob))
+		var b [binary.MaxVarintLen32]byte
+		expect(b[:binary.PutVarint(b[:], int64(v))], blob[3:])
+	}
+}
+
+func TestNumbersAsFloat(t *testing.T) {
+	asFloat := func(e *Encoder) { e.NumbersAsFloat = true }
+	expect([]byte{bcVersion, 0, tagFloat64, 0, 0, 0, 0, 0, 0, 0x14, 0x40}, tryEncode(int32(5), asFloat))
+	for _, v := range []any{5, uint8(5), Number("5"), 5.0} {
+		expect(tryWriteValue(5.0), tryEncode(v, asFloat))
+	}
+	expect(tryWriteValue(BoxedNumber{1.5}), tryEncode(BoxedNumber{1.5}, asFloat))
+	expect(BoxedNumber{5}, tryReadValue(tryEncode(BoxedNumber{5}, asFloat)))
+	expect([]any{5.0, []int32{5}}, tryReadValue(tryEncode([]any{int32(5), []int32{5}}, asFloat)))
+	expect([]any{5.0, 1.5}, tryReadValue(tryEncode(json.RawMessage("[5, 1.5]"), asFloat)))
+}
+
+func TestWriteValueOrdered(t *testing.T) {
+	m := map[string]any{"a": int32(1), "b": "x", "c": true}
+	buf := bytes.Buffer{}
+	expect(nil, WriteValueOrdered(&buf, m, []string{"c", "a", "b"}))
+	expect([]string{"c", "a", "b"}, tryReadAtoms(buf.Bytes()))
+	v := tryDecode(buf.Bytes(), func(d *Decoder) { d.OrderedObjects = true })
+	expect(&Object{[]Property{{"c", true}, {"a", int32(1)}, {"b", "x"}}}, v)
+	err := WriteValueOrdered(&bytes.Buffer{}, m, []string{"c", "a", "b", "d"})
+	expect(`serde.WriteValueOrdered: missing key "d"`, err.Error())
+	err = WriteValueOrdered(&bytes.Buffer{}, m, []string{"c", "c"})
+	expect(`serde.WriteValueOrdered: duplicate key "c"`, err.Error())
+	err = WriteValueOrdered(&bytes.Buffer{}, m, []string{"c"})
+	expect(`serde.WriteValueOrdered: unlisted key "a"`, err.Error())
+	buf.Reset()
+	e := NewEncoder(&buf)
+	e.UnlistedKeys = true
+	expect(nil, e.EncodeOrdered(m, []string{"c"}))
+	expect([]string{"c", "a", "b"}, tryReadAtoms(buf.Bytes()))
+}
+
+func TestTruncatedTypedArray(t *testing.T) {
+	blob := tryWriteValue([]float64{1, 2, 3, 4})
+	expect([]byte{bcVersion, 0, tagTypedArray, float64Array, 4, 0, tagArrayBuffer, 32}, blob[:8])
+	_, err := ReadValue(bytes.NewReader(blob[:18]))
+	var te *TruncatedTypedArrayError
+	expect(true, errors.As(err, &te))
+	expect(&TruncatedTypedArrayError{float64Array, 4, 8, 10, 32, io.ErrUnexpectedEOF}, te)
+	expect(true, errors.Is(err, io.ErrUnexpectedEOF))
+	expect("truncated Float64Array of 4 elements at offset 8: read 10 of 32 arraybuffer bytes: unexpected EOF", err.Error())
+	_, err = ReadValue(bytes.NewReader(blob[:8]))
+	expect("truncated Float64Array of 4 elements at offset 8: read 0 of 32 arraybuffer bytes: unexpected EOF", err.Error())
+	_, err = ReadValueN(bytes.NewReader(blob[:18]), len(blob))
+	expect(true, errors.As(err, &te))
+}
+
+func TestWriteJSON(t *testing.T) {
+	blob := tryWriteValue(json.RawMessage(`{"a":1,"b":[2,3]}`))
+	expect(tryWriteValue(map[string]any{"a": int32(1), "b": []any{int32(2), int32(3)}}), blob)
+	expect(map[string]any{"a": int32(1), "b": []any{int32(2), int32(3)}}, tryReadValue(blob))
+	v := tryReadValue(tryWriteValue(map[string]any{
+		"j": json.RawMessage(`[1.5, 1e3, -0, 4294967296, "s", null, true, {}]`),
+		"f": 2.0,
+	}))
+	expect(map[string]any{
+		"j": []any{1.5, int32(1000), math.Copysign(0, -1), 4294967296.0, "s", nil, true, map[string]any{}},
+		"f": 2.0, // not narrowed outside the JSON
+	}, v)
+	err := WriteValue(&bytes.Buffer{}, json.RawMessage(`{`))
+	expect("serde.WriteValue: invalid JSON: unexpected end of JSON input", err.Error())
+	// a failed write doesn't leave the encoder narrowing numbers
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	e.MaxOutputBytes = 10
+	err = e.Encode(json.RawMessage(`[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]`))
+	expect("serde.WriteValue: output exceeds 10 bytes", err.Error())
+	e.MaxOutputBytes = 0
+	expect(nil, e.Encode(map[string]any{"f": 3.0}))
+	expect(map[string]any{"f": 3.0}, tryReadValue(buf.Bytes()))
+}
+
+func TestDate(t *testing.T) {
+	date := func(ms float64) []byte {
+		b := []byte{bcVersion, 0, tagDate, tagFloat64, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(b[4:], math.Float64bits(ms))
+		return b
+	}
+	for _, c := range []struct {
+		ms   float64
+		want time.Time
+	}{
+		{0, time.Unix(0, 0)},
+		{-62135596800000, time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)}, // new Date(-62135596800000)
+		{8.64e15, time.Date(275760, 9, 13, 0, 0, 0, 0, time.UTC)},   // max
+		{-8.64e15, time.Date(-271821, 4, 20, 0, 0, 0, 0, time.UTC)}, // min
+		{-1, time.Unix(-1, 999000000)},
+		{1.5, time.Unix(0, 1500000)},
+		{-0.5, time.Unix(-1, 999500000)},
+		{-0.25, time.Unix(-1, 999750000)},
+		{1700000000000.25, time.Unix(1700000000, 250000)},
+		{-62135596800000.125, time.Date(0, 12, 31, 23, 59, 59, 999875000, time.UTC)},
+		{1234567890123.4567, time.Unix(1234567890, 123456787)},
+	} {
+		have := tryReadValue(date(c.ms))
+		expect(c.want.UTC(), have)
+		expect(date(c.ms), tryWriteValue(have))
+		expect(nil, Validate(bytes.NewReader(date(c.ms))))
+	}
+	// fractional values far from the epoch survive even if they need more
+	// than nanosecond precision
+	for i := 0; i < 1000; i++ {
+		ms := (rand.Float64() - 0.5) * 1.7e15
+		expect(date(ms), tryWriteValue(tryReadValue(date(ms))))
+	}
+	expect(InvalidDate{}, tryReadValue(date(math.NaN())))
+	expect(date(math.NaN()), tryWriteValue(InvalidDate{}))
+	// QuickJS writes whole numbers as float64 too but accept int32
+	expect(time.Unix(0, 42000000).UTC(), tryReadValue([]byte{bcVersion, 0, tagDate, tagInt32, 84}))
+	// d = new Date(0); [d, d]
+	v := tryReadValue([]byte{bcVersion, 0, tagArray, 2, tagDate, tagInt32, 0, tagObjectReference, 1})
+	expect([]any{time.Unix(0, 0).UTC(), time.Unix(0, 0).UTC()}, v)
+	_, err := ReadValue(bytes.NewReader([]byte{bcVersion, 0, tagDate, tagString, 0}))
+	expect("serde.ReadValue: unsupported date value string", err.Error())
+	type s struct{ T time.Time }
+	blob := tryWriteValue(s{time.Unix(1, 0)})
+	expect(&s{time.Unix(1, 0).UTC()}, tryReadObject(&s{}, blob))
+}
+
+func TestByteOrder(t *testing.T) {
+	decode := func(blob []byte) any {
+		return tryDecode(blob, func(d *Decoder) { d.ByteOrder = binary.BigEndian })
+	}
+	expect(1.5, decode([]byte{bcVersion, 0, tagFloat64, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}))
+	expect([]float64{1.5, -2}, decode([]byte{bcVersion, 0, tagTypedArray, float64Array, 2, 0, tagArrayBuffer, 16,
+		0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xc0, 0, 0, 0, 0, 0, 0, 0}))
+	expect([]int16{1, -2}, decode([]byte{bcVersion, 0, tagTypedArray, int16Array, 2, 0, tagArrayBuffer, 4, 0, 1, 0xff, 0xfe}))
+	expect("\u2603", decode([]byte{bcVersion, 0, tagString, 3, 0x26, 0x03}))
+	// typed arrays that share a buffer are decoded from the same bytes
+	v := decode([]byte{bcVersion, 0, tagArray, 2,
+		tagTypedArray, uint16Array, 2, 0, tagArrayBuffer, 4, 0, 1, 0, 2,
+		tagTypedArray, uint16Array, 1, 2, tagObjectReference, 2})
+	expect([]any{[]uint16{1, 2}, []uint16{2}}, v)
+	// little-endian is the default
+	blob := tryWriteValue([]any{1.5, []float64{1.5}, "\u2603"})
+	expect(tryReadValue(blob), tryDecode(blob, func(d *Decoder) { d.ByteOrder = binary.LittleEndian }))
+}
+
+func TestWritePointers(t *testing.T) {
+	type Inner struct{ N int }
+	type Outer struct {
+		A, B *Inner
+		C    **Inner
+		S    *string
+	}
+	inner := &Inner{1}
+	s := "x"
+	v := Outer{A: inner, C: &inner, S: &s}
+	want := map[string]any{
+		"A": map[string]any{"N": int32(1)},
+		"B": nil,
+		"C": map[string]any{"N": int32(1)},
+		"S": "x",
+	}
+	expect(want, tryReadValue(tryWriteValue(v)))
+	expect(want, tryReadValue(tryWriteValue(&v)))
+	have := &Outer{}
+	expect(nil, ReadObject(bytes.NewReader(tryWriteValue(Outer{A: inner})), have))
+	expect(&Outer{A: &Inner{1}}, have)
+	// shared pointers are written as copies, cycles are rejected
+	expect([]any{map[string]any{"N": int32(1)}, map[string]any{"N": int32(1)}},
+		tryReadValue(tryWriteValue([]*Inner{inner, inner})))
+	type Node struct{ Next *Node }
+	n := &Node{}
+	n.Next = n
+	expect("serde.WriteValue: cyclic value", WriteValue(io.Discard, n).Error())
+	// a struct and its first field share an address but aren't a cycle
+	type Self struct {
+		X int
+		P *int
+	}
+	self := &Self{X: 7}
+	self.P = &self.X
+	expect(map[string]any{"X": int32(7), "P": int32(7)}, tryReadValue(tryWriteValue(self)))
+}
+
+func TestWriteStructSlice(t *testing.T) {
+	type Record struct {
+		ID    int    `js:"id"`
+		Name  string `js:"name"`
+		Tags  []string
+		Owner struct{ Name string } `js:"owner"`
+		Skip  bool                  `js:"-"`
+	}
+	records := []Record{{ID: 1, Name: "a", Tags: []string{"x"}}, {ID: 2, Name: "b", Tags: []string{}, Skip: true}}
+	records[1].Owner.Name = "o"
+	blob := tryWriteValue(records)
+	// field names are stored once and shared by all elements
+	expect([]string{"id", "name", "Tags", "owner", "Name"}, tryReadAtoms(blob))
+	expect([]any{
+		map[string]any{"id": int32(1), "name": "a", "Tags": []any{"x"}, "owner": map[string]any{"Name": ""}},
+		map[string]any{"id": int32(2), "name": "b", "Tags": []any{}, "owner": map[string]any{"Name": "o"}},
+	}, tryReadValue(blob))
+	// and back
+	var have struct{ R []Record }
+	tryReadObject(&have, tryWriteValue(map[string]any{"R": records}))
+	records[1].Skip = false
+	expect(records, have.R)
+	expect([]byte{bcVersion, 0, tagArray, 0}, tryWriteValue([]Record{}))
+}
+
+func TestWriteFloat32(t *testing.T) {
+	expect(float64(float32(0.1)), tryReadValue(tryWriteValue(float32(0.1))))
+	expect(tryWriteValue(1.5), tryWriteValue(float32(1.5)))
+	expect(math.Inf(-1), tryReadValue(tryWriteValue(float32(math.Inf(-1)))))
+	expect([]any{float64(float32(2.2))}, tryReadValue(tryWriteValue([]any{float32(2.2)})))
+}
+
+func TestIntern(t *testing.T) {
+	blob := tryWriteValue([]any{"red", map[string]any{"color": "red"}, "blue"})
+	v := tryReadValue(blob).([]any)
+	a, b := v[0].(string), v[1].(map[string]any)["color"].(string)
+	expect(false, unsafe.StringData(a) == unsafe.StringData(b))
+	seen := map[string]string{}
+	v = tryDecode(blob, func(d *Decoder) {
+		d.Intern = func(s string) string {
+			if t, ok := seen[s]; ok {
+				return t
+			}
+			seen[s] = s
+			return s
+		}
+	}).([]any)
+	a, b = v[0].(string), v[1].(map[string]any)["color"].(string)
+	expect("red", b)
+	expect(true, unsafe.StringData(a) == unsafe.StringData(b))
+	expect(2, len(seen))
+}
+
+func TestStringAtoms(t *testing.T) {
+	v := map[string]any{"42": int32(1), "007": int32(2)}
+	expect([]byte{
+		bcVersion, 1, 6, '0', '0', '7',
+		tagObject, 2, 2, tagInt32, 4, 42<<1 | 1, tagInt32, 2,
+	}, tryWriteValue(v))
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	e.StringAtoms = true
+	expect(nil, e.Encode(v))
+	expect([]byte{
+		bcVersion, 2, 6, '0', '0', '7', 4, '4', '2',
+		tagObject, 2, 2, tagInt32, 4, 4, tagInt32, 2,
+	}, buf.Bytes())
+	expect(v, tryReadValue(buf.Bytes()))
+}
+
+func TestWriteUnsupportedType(t *testing.T) {
+	for _, v := range []any{make(chan int), func() {}} {
+		err := WriteValue(io.Discard, v)
+		expect(true, errors.Is(err, ErrUnsupportedType))
+		var ute *UnsupportedTypeError
+		expect(true, errors.As(err, &ute))
+		expect(reflect.TypeOf(v), ute.Type)
+	}
+	err := WriteValue(io.Discard, make(chan int))
+	expect("unsupported type chan int (chan)", err.Error())
+}
+
+func TestWriteObject(t *testing.T) {
+	type Inner struct {
+		N float64
+	}
+	type Embedded struct {
+		E string
+	}
+	type s struct {
+		Embedded
+		Name    string `js:"name"`
+		Count   int
+		Big     int64
+		Flag    bool
+		Samples []float32
+		Inner   Inner
+		Skip    string `js:"-"`
+		private int
+	}
+	in := s{Embedded{"e"}, "x", 42, 1 << 40, true, []float32{1.5}, Inner{2.5}, "skip", 7}
+	buf := bytes.Buffer{}
+	expect(nil, WriteObject(&buf, &in))
+	expect([]string{"E", "name", "Count", "Big", "Flag", "Samples", "Inner", "N"}, tryReadAtoms(buf.Bytes()))
+	out := s{}
+	expect(nil, ReadObject(bytes.NewReader(buf.Bytes()), &out))
+	expect(s{Embedded{"e"}, "x", 42, 1 << 40, true, []float32{1.5}, Inner{2.5}, "", 0}, out)
+	// nested structs decode as maps
+	expect(map[string]any{"N": 2.5}, tryReadValue(buf.Bytes()).(map[string]any)["Inner"])
+	err := WriteObject(&buf, 42)
+	expect("serde.WriteObject: struct expected, have int", err.Error())
+}
+
+func TestWriteNestedReflect(t *testing.T) {
+	type Leaf struct {
+		Weights []float64 `js:"weights"`
+		Tags    []string  `js:"tags"`
+	}
+	type Branch struct {
+		Leaves map[string]Leaf `js:"leaves"`
+		Counts map[string]int  `js:"counts"`
+	}
+	type Tree struct {
+		Branches []Branch       `js:"branches"`
+		Meta     map[string]any `js:"meta"`
+	}
+	v := Tree{
+		Branches: []Branch{{
+			Leaves: map[string]Leaf{"l": {[]float64{0.5}, []string{"x", "y"}}},
+			Counts: map[string]int{"z": 3, "a": 1 << 40},
+		}},
+		Meta: map[string]any{"m": []int16{7}},
+	}
+	blob := tryWriteValue(v)
+	expect([]string{"branches", "leaves", "l", "weights", "tags", "counts", "a", "z", "meta", "m"}, tryReadAtoms(blob))
+	expect(map[string]any{
+		"branches": []any{map[string]any{
+			"leaves": map[string]any{"l": map[string]any{"weights": []float64{0.5}, "tags": []any{"x", "y"}}},
+			"counts": map[string]any{"z": int32(3), "a": float64(1 << 40)},
+		}},
+		"meta": map[string]any{"m": []int16{7}},
+	}, tryReadValue(blob))
+	// map keys must be strings
+	err := WriteValue(io.Discard, map[int]string{1: "x"})
+	expect(true, errors.Is(err, ErrUnsupportedType))
+	// cycles through reflected values are detected
+	type node map[string]any
+	n := node{}
+	n["self"] = []node{n}
+	err = WriteValue(io.Discard, n)
+	expect("serde.WriteValue: cyclic value", err.Error())
+}
+
+func TestEmptyObjectAndArray(t *testing.T) {
+	object := []byte{bcVersion, 0, tagObject, 0}
+	array := []byte{bcVersion, 0, tagArray, 0}
+	expect(map[string]any{}, tryReadValue(object))
+	expect([]any{}, tryReadValue(array))
+	expect(object, tryWriteValue(tryReadValue(object)))
+	expect(array, tryWriteValue(tryReadValue(array)))
+	ordered := tryDecode(object, func(d *Decoder) { d.OrderedObjects = true })
+	expect(object, tryWriteValue(ordered))
+	// nil maps and slices keep their kind
+	expect(object, tryWriteValue(map[string]any(nil)))
+	expect(array, tryWriteValue([]any(nil)))
+	expect(object, tryWriteValue(map[string]int(nil)))
+	expect(array, tryWriteValue([]string(nil)))
+	nested := tryWriteValue(map[string]any{"o": map[string]any{}, "a": []any{}})
+	expect(map[string]any{"o": map[string]any{}, "a": []any{}}, tryReadValue(nested))
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	v := map[string]any{"k": "v"}
+	blob := tryWriteValue(v)
+	for _, max := range []int{len(blob), len(blob) + 1} {
+		buf := bytes.Buffer{}
+		e := NewEncoder(&buf)
+		e.MaxOutputBytes = max
+		expect(nil, e.Encode(v))
+		expect(blob, buf.Bytes())
+	}
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	e.MaxOutputBytes = len(blob) - 1
+	expect(fmt.Sprintf("serde.WriteValue: output exceeds %d bytes", len(blob)-1), e.Encode(v).Error())
+	expect(0, buf.Len())
+	// aborts partway through, without visiting the rest of the value
+	type thunk func() int32
+	visited := 0
+	big := make([]any, 1e5)
+	for i := range big {
+		big[i] = thunk(func() int32 { return 42 })
+	}
+	e.MaxOutputBytes = 1000
+	e.OnUnsupported = func(v any) (any, error) {
+		visited++
+		return v.(thunk)(), nil
+	}
+	expect("serde.WriteValue: output exceeds 1000 bytes", e.Encode(big).Error())
+	expect(true, visited > 0 && visited < 1000)
+	expect(0, buf.Len())
+}
+
+func TestVersionMismatch(t *testing.T) {
+	blob := tryWriteValue("x")
+	blob[0] = bcVersion - 1
+	for _, f := range []func() error{
+		func() error { _, err := ReadValue(bytes.NewReader(blob)); return err },
+		func() error { return ReadObject(bytes.NewReader(blob), &struct{}{}) },
+		func() error { _, err := ReadAtoms(bytes.NewReader(blob)); return err },
+	} {
+		err := f()
+		var vme *VersionMismatchError
+		expect(true, errors.As(err, &vme))
+		expect(VersionMismatchError{bcVersion - 1, bcVersion}, *vme)
+		expect(fmt.Sprintf("version mismatch (have %d, want %d)", bcVersion-1, bcVersion), err.Error())
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	blob := tryWriteValue(errors.New("boom"))
+	expect(map[string]any{"message": "boom"}, tryReadValue(blob))
+}
+
+func TestCanonicalize(t *testing.T) {
+	// {a: 1, b: 2} with atoms in a,b order and b written as int32
+	a := []byte{bcVersion, 2, 2, 97, 2, 98, 8, 2, 2, 5, 2, 4, 5, 4}
+	// {b: 2.0, a: 1} with atoms in b,a order and b written as float64
+	b := []byte{bcVersion, 2, 2, 98, 2, 97, 8, 2, 2, 6, 0, 0, 0, 0, 0, 0, 0, 64, 4, 5, 2}
+	ca, err := Canonicalize(a)
+	expect(nil, err)
+	cb, err := Canonicalize(b)
+	expect(nil, err)
+	expect(a, ca)
+	expect(ca, cb)
+	// arraybuffers stay arraybuffers
+	ab := []byte{bcVersion, 0, 15, 1, 42}
+	cab, err := Canonicalize(ab)
+	expect(nil, err)
+	expect(ab, cab)
+	// -0 can't be narrowed
+	nz := tryWriteValue(math.Copysign(0, -1))
+	cnz, err := Canonicalize(nz)
+	expect(nil, err)
+	expect(nz, cnz)
+	// narrow strings are latin1, {"\xe9": "\xe9"} is {"é": "é"}
+	latin1 := []byte{bcVersion, 1, 2, 0xE9, tagObject, 1, 2, tagString, 2, 0xE9}
+	cl, err := Canonicalize(latin1)
+	expect(nil, err)
+	expect(tryWriteValue(map[string]any{"é": "é"}), cl)
+	cl, err = Canonicalize([]byte{bcVersion, 0, tagString, 2, 0xE9})
+	expect(nil, err)
+	expect("é", tryReadValue(cl))
+	_, err = Canonicalize([]byte{bcVersion, 0, 9, 1, 20, 0})
+	expect("serde.WriteValue: cyclic value", err.Error())
+	_, err = Canonicalize([]byte{bcVersion, 0, 1, 1})
+	expect("serde.Canonicalize: 1 bytes of trailing data", err.Error())
+}
+
+func TestTagName(t *testing.T) {
+	expect("null", TagName(tagNull))
+	expect("int32", TagName(tagInt32))
+	expect("object", TagName(tagObject))
+	expect("typed array", TagName(tagTypedArray))
+	expect("object reference", TagName(tagObjectReference))
+	expect("unknown tag 0", TagName(0))
+	expect("unknown tag 255", TagName(255))
+	expect("Uint8ClampedArray", TypedArrayKindName(uint8ClampedArray))
+	expect("Uint8Array", TypedArrayKindName(uint8Array))
+	expect("BigUint64Array", TypedArrayKindName(bigUint64Array))
+	expect("Float64Array", TypedArrayKindName(float64Array))
+	expect("unknown typed array kind 42", TypedArrayKindName(42))
 }
 
 func tryReadValue(b []byte) any {
@@ -72,6 +2051,26 @@ func tryReadValue(b []byte) any {
 	return v
 }
 
+func tryDecode(b []byte, configure func(d *Decoder)) any {
+	d := NewDecoder(bytes.NewReader(b))
+	configure(d)
+	v, err := d.Decode()
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func tryEncode(v any, configure func(e *Encoder)) []byte {
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	configure(e)
+	if err := e.Encode(v); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
 func tryReadObject(v any, b []byte) any {
 	br := bytes.NewReader(b)
 	if err := ReadObject(br, v); err != nil {
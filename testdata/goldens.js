@@ -0,0 +1,43 @@
+// Prints what JS_WriteObject() produces for the expressions in the goldens
+// table in compat_test.go, one per line, in the same layout as the table
+// minus the version byte. Run it with a QuickJS-ng build:
+//
+//	qjs --module testdata/goldens.js
+//
+// Keep the list in sync with the table.
+import * as bjson from "qjs:bjson";
+
+const exprs = [
+	"null",
+	"undefined",
+	"false",
+	"true",
+	"42",
+	"-1",
+	"-2147483648",
+	"1.5",
+	"-0",
+	`"ok"`,
+	`"😭"`,
+	`[1, "a", null]`,
+	`{a: 1, b: "x"}`,
+	`{x: {y: 1}, y: 2}`,
+	`{0: true, 10: false}`,
+	"new Uint8Array([1, 2])",
+	"new Int16Array([1, -1])",
+	"new Float64Array([1.5])",
+	"new Uint8ClampedArray([255])",
+	"new ArrayBuffer(2)",
+	"Object(true)",
+	`new String("s")`,
+	"-129n",
+	"new Date(1)",
+];
+
+const hex = (b) => "0x" + b.toString(16).padStart(2, "0");
+
+for (const js of exprs) {
+	const v = (0, eval)(`(${js})`);
+	const blob = new Uint8Array(bjson.write(v)).subarray(1);
+	console.log(`${JSON.stringify(js)}: []byte{${Array.from(blob, hex).join(", ")}},`);
+}
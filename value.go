@@ -0,0 +1,193 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"unsafe"
+)
+
+// Value is a read-only view of a decoded tree. It has no methods that
+// modify the tree and its accessors never hand out the underlying maps or
+// slices, so a Value can be shared between goroutines without locking.
+// The zero Value is null.
+type Value struct {
+	v any
+}
+
+// ReadImmutable is like ReadValue but returns a Value.
+func ReadImmutable(r io.Reader) (Value, error) {
+	return NewDecoder(r).DecodeImmutable()
+}
+
+// DecodeImmutable is like Decode but returns a Value. The decoded tree is
+// only reachable through the Value.
+func (d *Decoder) DecodeImmutable() (Value, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{v}, nil
+}
+
+// Get looks up path like the Get function. Missing paths return an
+// undefined Value and false.
+func (v Value) Get(path string) (Value, bool) {
+	if w, ok := Get(v.v, path); ok {
+		return Value{w}, true
+	}
+	return Value{Undefined}, false
+}
+
+// Index returns the i-th element of an array, or undefined if v is not an
+// array or i is out of range.
+func (v Value) Index(i int) Value {
+	if a, ok := v.v.([]any); ok && i >= 0 && i < len(a) {
+		return Value{a[i]}
+	}
+	return Value{Undefined}
+}
+
+// Len returns the number of elements of an array, or of properties of an
+// object, and 0 for everything else.
+func (v Value) Len() int {
+	switch t := v.v.(type) {
+	case []any:
+		return len(t)
+	case map[string]any:
+		return len(t)
+	case *Object:
+		return len(t.Props)
+	}
+	return 0
+}
+
+// Keys returns the property names of an object, sorted, or in insertion
+// order for ordered objects. The slice is a copy.
+func (v Value) Keys() []string {
+	var keys []string
+	switch t := v.v.(type) {
+	case map[string]any:
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	case *Object:
+		for _, p := range t.Props {
+			keys = append(keys, p.Key)
+		}
+	}
+	return keys
+}
+
+func (v Value) IsUndefined() bool { return v.v == Undefined }
+
+func (v Value) IsNull() bool { return v.v == nil }
+
+func (v Value) IsArray() bool {
+	_, ok := v.v.([]any)
+	return ok
+}
+
+func (v Value) IsObject() bool {
+	switch v.v.(type) {
+	case map[string]any, *Object:
+		return true
+	}
+	return false
+}
+
+func (v Value) AsString() (string, bool) { return GetString(v.v, "") }
+
+func (v Value) AsInt() (int64, bool) { return GetInt(v.v, "") }
+
+func (v Value) AsFloat() (float64, bool) { return GetFloat(v.v, "") }
+
+func (v Value) AsBool() (bool, bool) { return GetBool(v.v, "") }
+
+// Interface returns a deep copy of the tree that the caller is free to
+// modify. Objects and arrays that occur more than once, cycles included,
+// are copied once and shared in the copy like they are in the tree.
+func (v Value) Interface() any {
+	return deepCopy(v.v, map[unsafe.Pointer]any{})
+}
+
+// deepCopy copies v. seen maps the objects and arrays copied so far to
+// their copies, see identity.
+func deepCopy(v any, seen map[unsafe.Pointer]any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		if c, ok := seen[identity(t)]; ok {
+			return c
+		}
+		m := make(map[string]any, len(t))
+		seen[identity(t)] = m
+		for k, e := range t {
+			m[k] = deepCopy(e, seen)
+		}
+		return m
+	case []any:
+		if len(t) == 0 {
+			return []any{}
+		}
+		if c, ok := seen[identity(t)]; ok {
+			return c
+		}
+		a := make([]any, len(t))
+		seen[identity(t)] = a
+		for i, e := range t {
+			a[i] = deepCopy(e, seen)
+		}
+		return a
+	case *Object:
+		if c, ok := seen[identity(t)]; ok {
+			return c
+		}
+		o := &Object{Props: make([]Property, len(t.Props))}
+		seen[identity(t)] = o
+		for i, p := range t.Props {
+			o.Props[i] = Property{p.Key, deepCopy(p.Value, seen)}
+		}
+		return o
+	case *big.Int:
+		return new(big.Int).Set(t)
+	case []*big.Int:
+		a := make([]*big.Int, len(t))
+		for i, n := range t {
+			a[i] = new(big.Int).Set(n)
+		}
+		return a
+	case ArrayBuffer:
+		return ArrayBuffer{deepCopy(t.Bytes, seen).([]byte)}
+	case Uint8ClampedArray:
+		return Uint8ClampedArray{deepCopy(t.Bytes, seen).([]byte)}
+	}
+	// typed arrays, []byte and StringBytes
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && !rv.IsNil() {
+		c := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		reflect.Copy(c, rv)
+		return c.Interface()
+	}
+	return v
+}
+
+// identity returns the address of a map or of the backing array of a
+// slice. Maps and slices are never moved so the address is stable.
+func identity(v any) unsafe.Pointer {
+	return reflect.ValueOf(v).UnsafePointer()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024, Ben Noordhuis <info@bnoordhuis.nl>
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package serde
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	blob := tryWriteValue(map[string]any{
+		"name":  "x",
+		"users": []any{map[string]any{"name": "alice", "age": int32(42)}},
+		"pi":    3.14,
+		"ok":    true,
+		"none":  nil,
+		"u":     Undefined,
+		"b":     []byte{1, 2},
+	})
+	v, err := ReadImmutable(bytes.NewReader(blob))
+	expect(nil, err)
+	expect(true, v.IsObject())
+	expect(7, v.Len())
+	expect([]string{"b", "name", "none", "ok", "pi", "u", "users"}, v.Keys())
+	users, ok := v.Get("users")
+	expect(true, ok)
+	expect(true, users.IsArray())
+	expect(1, users.Len())
+	s, ok := users.Index(0).Get("name")
+	expect(true, ok)
+	name, ok := s.AsString()
+	expect("alice", name)
+	expect(true, ok)
+	age, _ := v.Get("users[0].age")
+	n, ok := age.AsInt()
+	expect(int64(42), n)
+	expect(true, ok)
+	pi, _ := v.Get("pi")
+	f, ok := pi.AsFloat()
+	expect(3.14, f)
+	expect(true, ok)
+	b, _ := v.Get("ok")
+	ok, _ = b.AsBool()
+	expect(true, ok)
+	none, _ := v.Get("none")
+	expect(true, none.IsNull())
+	u, _ := v.Get("u")
+	expect(true, u.IsUndefined())
+	missing, ok := v.Get("users[1]")
+	expect(false, ok)
+	expect(true, missing.IsUndefined())
+	expect(true, users.Index(-1).IsUndefined())
+	expect(true, Value{}.IsNull())
+	// Interface returns a copy
+	m := v.Interface().(map[string]any)
+	m["name"] = "y"
+	m["users"].([]any)[0].(map[string]any)["name"] = "bob"
+	m["b"].([]byte)[0] = 42
+	expect(tryReadValue(blob), v.Interface())
+	// no way to modify a Value through its methods
+	typ := reflect.TypeOf(&v)
+	expect(reflect.TypeOf(v).NumMethod(), typ.NumMethod()) // no pointer receivers
+	for i := 0; i < typ.NumMethod(); i++ {
+		name := typ.Method(i).Name
+		for _, prefix := range []string{"Set", "Delete", "Append", "Put", "Add"} {
+			expect(false, strings.HasPrefix(name, prefix))
+		}
+	}
+}
+
+func TestValueInterfaceCopies(t *testing.T) {
+	// a = []; a.push(a)
+	v, err := ReadImmutable(bytes.NewReader([]byte{bcVersion, 0, tagArray, 1, tagObjectReference, 0}))
+	expect(nil, err)
+	a := v.Interface().([]any)
+	expect(1, len(a))
+	expect(&a[0], &a[0].([]any)[0]) // still a cycle, in the copy
+	orig, _ := v.Index(0).v.([]any)
+	expect(false, &orig[0] == &a[0])
+	// o = {}; o.o = o, with OrderedObjects too
+	blob := []byte{bcVersion, 1, 2, 'o', tagObject, 1, 2, tagObjectReference, 0}
+	v, err = ReadImmutable(bytes.NewReader(blob))
+	expect(nil, err)
+	m := v.Interface().(map[string]any)
+	expect(reflect.ValueOf(m).UnsafePointer(), reflect.ValueOf(m["o"]).UnsafePointer())
+	d := NewDecoder(bytes.NewReader(blob))
+	d.OrderedObjects = true
+	v, err = d.DecodeImmutable()
+	expect(nil, err)
+	o := v.Interface().(*Object)
+	expect(o, o.Props[0].Value)
+	// BigInts aren't shared either
+	d = NewDecoder(bytes.NewReader(tryWriteValue([]any{big.NewInt(1), []uint64{2}})))
+	d.BigIntArrays = true
+	v, err = d.DecodeImmutable()
+	expect(nil, err)
+	c := v.Interface().([]any)
+	c[0].(*big.Int).SetInt64(42)
+	c[1].([]*big.Int)[0].SetInt64(42)
+	expect([]any{big.NewInt(1), []*big.Int{big.NewInt(2)}}, v.Interface())
+}